@@ -1,17 +1,28 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
 func usage() {
-	fmt.Fprintln(os.Stderr, `Usage:
+	io.WriteString(os.Stderr, `Usage:
 
 sd [options] 'command'
+sd [options] --index FILE
+sd [options] --bloom FILE
+sd index FILE
+sd bloom [--false-positive-rate %rate%] FILE
+sd serve [--listen %address%] [--max-jobs %jobs%] [--max-body-size %bytes%] [--upload-timeout %seconds%]
 
 Examples
 
@@ -23,6 +34,12 @@ Examples
 
   mysql -Nsr -e "SELECT city FROM users" | sd -p 0 -t 10 kafka_consumer --topic excluded_cities > active_cities.txt 
 
+  mysql -Nsr -e "SELECT city FROM excluded_cities" | sd index excluded_cities.idx
+  mysql -Nsr -e "SELECT city FROM users" | sd --index excluded_cities.idx
+
+  mysql -Nsr -e "SELECT id FROM notified_users" | sd bloom --false-positive-rate 0.001 notified_users.bloom
+  mysql -Nsr -e "SELECT id FROM users" | sd --bloom notified_users.bloom
+
 Options
 
 	-f --follow: keeps reading from STDIN until SIGINT or its end.
@@ -31,17 +48,130 @@ Options
 	-t --timeout %seconds%: exit(0) after specified seconds from last received line. STDIN and command have independent timeouts. When with -f, timeout only applies to the command (not to STDIN).
 	-h --hard-timeout %seconds%: exit(0) after the specified seconds (or earlier). Overrides all other options.
 	--intersection: outputs the intersection between the two streams.
+	--index %file%: diffs against an index built with 'sd index' instead of running a command.
+	--false-positive-rate %rate%: keeps a Bloom filter of the command's lines instead of the lines themselves, using a fraction of the memory. Up to the specified rate of lines (e.g. 0.001) are wrongly considered to be in the command.
+	--bloom %file%: diffs against a Bloom filter built with 'sd bloom' instead of running a command.
+	--state %file%: adds the lines in the specified file to the command's lines, and saves the output lines (or the command's lines, with --state-source command) to it on exit, so that every run only outputs new lines.
+	--state-source %source%: which lines to save to the --state file: "emitted" (default) or "command".
+	--state-retention %seconds%: drops lines from the --state file that weren't seen in the specified seconds. Use 0 for keeping them forever (default).
+	--checkpoint %dir%: saves the command's lines and how many STDIN lines were processed to the specified directory periodically and on exit, to be resumed with --resume.
+	--checkpoint-interval %seconds%: how often to save the --checkpoint. Defaults to 60.
+	--resume: loads the command's lines from the --checkpoint and skips the STDIN lines that were already processed, which assumes that STDIN is replayed from the start (e.g. a file).
+	-z --zero-terminated: records in both streams and in the output are separated by NUL rather than newlines, like 'find -print0'.
+	--stdin-delimiter %delimiter%: records in STDIN are separated by the specified string, which can contain the escapes \0, \n, \r, \t, \\ and \xHH.
+	--cmd-delimiter %delimiter%: records in the command are separated by the specified string, which can contain the same escapes as --stdin-delimiter.
+	--output-delimiter %delimiter%: records in the output are separated by the specified string, which can contain the same escapes as --stdin-delimiter.
+	--stdin-delimiter-regex %regex%: records in STDIN are separated by matches of the specified regex.
+	--cmd-delimiter-regex %regex%: records in the command are separated by matches of the specified regex.
+	--max-record-size %bytes%: the maximum size of a record in either stream. Defaults to 65536.
+	--oversized %policy%: what to do with records longer than --max-record-size: "fail" (default), "truncate" or "skip". Truncated and skipped records are reported in STDERR.
+	--paragraph: records in both streams are paragraphs, i.e. groups of lines separated by blank lines.
+	--record-start %regex%: records in both streams start at each line that matches the specified regex, and span until the next one, e.g. '^\S' for stack traces.
+	--record-lines %lines%: records in both streams are groups of the specified number of lines.
+	--csv: both streams are CSV with a header, whose records can span several lines. The output is CSV too, with STDIN's header.
+	--key %columns%: compares only the specified comma separated columns of each record rather than the whole record. With --csv, columns are header names; otherwise they're field numbers starting at 1, e.g. '--key 2,1'.
+	--stdin-key %columns%: like --key, but only for STDIN.
+	--cmd-key %columns%: like --key, but only for the command. The columns can be in a different order than in STDIN, e.g. '--stdin-key 1,3 --cmd-key 2,1'.
+	--field-separator %separator%: the separator of the fields numbered by --key. Defaults to a tab, and can contain the same escapes as --stdin-delimiter.
+	--numeric: compares keys (or whole records) as numbers, so that e.g. 007, 7 and 7.0 are equal.
+	--tolerance %delta%: like --numeric, but numbers that differ by up to the specified delta are equal too. Can't be used with several key columns.
+	--fuzzy %distance%: records are equal if their Levenshtein distance (the number of characters inserted, deleted or replaced) is up to the specified distance.
+	--show-match: with --fuzzy and --intersection, outputs each record followed by --field-separator and its closest match in the command.
+	--match %mode%: how records are compared with the command's records: "exact" (default), or treating the command's records as "glob" patterns (e.g. *.example.com), "regex" patterns (e.g. ^test_), "prefix"es or "substring"s, so records matching any of them are in the command.
+	--join %kind%: outputs each STDIN record joined to the command's records with the same key, like a relational join that doesn't need sorted input: "inner" outputs a record per match, "left" also outputs records without matches, and "anti" only outputs records without matches. Records are joined by --field-separator unless there's a --template.
+	--template %template%: formats each output record with the specified Go text/template, which can use {{.Line}}, {{.Key}}, {{.Fields}}, {{.Side}} ("left", "right", "common" or "changed"), {{.Number}} (in its stream, starting at 1), {{.Match}} (with --join or --show-match), {{.Changed}} (with --changes) and {{.Time}}, and the functions join, json, sql (for MySQL) and ansisql, e.g. 'INSERT INTO users VALUES ({{sql .Line}});'.
+	--changes: compares records with the same key field by field, and outputs each record preceded by its category and --field-separator: "left" if it's only in STDIN, "right" if it's only in the command, "changed" if other fields differ (followed by the numbers of those fields), or "common" otherwise, only with --intersection. Needs --key, --stdin-key or --cmd-key.
+	--decompress: detects gzip, bzip2 and zstd compressed streams by their first bytes and decompresses them. zstd needs the zstd command.
+	--gzip-output: compresses the output with gzip.
+	--sink %side%=%path%: sends the output records of a side ("left" if only in STDIN, "right" if only in the command, "common", "changed" or "all") to the specified file or FIFO, or to STDOUT if it's -. Can be repeated, e.g. '--sink left=missing.txt --sink right=extra.txt --sink all=-'. Defaults to all=-.
+	--output %path%: writes the output to the specified file rather than STDOUT, like '--sink all=%path%'. Files are written to a temporary file that's renamed when sd finishes, so they never have partial output. The path can contain %n and %t, which are replaced by the number of the file (continuing from the files of previous runs) and the time it was started.
+	--rotate-size %bytes%: with --output or --sink files, starts a new file once the current one has the specified bytes of output. The path must contain %n.
+	--rotate-interval %seconds%: with --output or --sink files, starts a new file once the current one has been written to for the specified seconds. The path must contain %n or %t.
+	--color %when%: colors the output records by side: red if only in STDIN, green if only in the command, yellow if changed and dim if common. "auto" (default) colors them only when writing to a terminal, "always" or "never".
+	-n --line-number: precedes each output record with its number in its stream, starting at 1.
+	--progress: shows in STDERR how many lines were read from each stream, when their timeouts fire, how many lines are in the diffee and pending to be diffed, and how many were output, updated in place.
+	--metrics %address%: serves Prometheus metrics in /metrics on the specified address, e.g. ':9100': lines read, timeout resets, lines matched and output, lines in the diffee, pending lines and goroutines.
+	--stdin-encoding %encoding%: converts STDIN from the specified encoding to UTF-8: "utf-8", "latin-1", "windows-1252", "utf-16" (big endian unless there's a BOM), "utf-16le" or "utf-16be".
+	--cmd-encoding %encoding%: like --stdin-encoding, but for the command.
+	--invalid-bytes %policy%: what to do with byte sequences that aren't valid in the --stdin-encoding or --cmd-encoding: "replace" them with U+FFFD (default), "skip" them or "fail".
+	--spill %partitions%: partitions both streams into the specified number of temporary files and diffs them one pair at a time, for streams that don't fit in memory. Output starts once both streams finish, so it can't be used with -f.
+
+Commands
+
+	index %file%: builds an index file from the lines in STDIN, to be used with --index. Lines are whole and separated by newlines, whatever the options of the diff.
+	bloom [--false-positive-rate %rate%] %file%: builds a Bloom filter file from the lines in STDIN, to be used with --bloom. The rate defaults to 0.01. Lines are whole and separated by newlines, whatever the options of the diff.
+	serve [--listen %address%] [--max-jobs %jobs%] [--max-body-size %bytes%] [--upload-timeout %seconds%]: serves diffs over HTTP on the address (default :8080), running up to the specified jobs at once (default 8) with request bodies up to the specified bytes (default 1073741824). Jobs whose streams don't start uploading within the timeout (default 60) are killed. See the README.
+
 
 `)
 }
 
+// multiFlag is a flag that can be repeated.
+type multiFlag []string
+
+func (f *multiFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *multiFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
 type options struct {
-	follow      bool
-	infinite    bool
-	intersection    bool
-	patience    int
-	timeoutF    int
-	hardTimeout int
+	follow              bool
+	infinite            bool
+	intersection        bool
+	patience            int
+	timeoutF            int
+	hardTimeout         int
+	index               string
+	spill               int
+	bloomRate           float64
+	bloom               string
+	state               string
+	stateSource         string
+	stateRetention      int
+	checkpoint          string
+	checkpointInterval  int
+	resume              bool
+	zeroTerminated      bool
+	stdinDelimiter      string
+	cmdDelimiter        string
+	outputDelimiter     string
+	stdinDelimiterRegex string
+	cmdDelimiterRegex   string
+	maxRecordSize       int
+	oversized           string
+	paragraph           bool
+	recordStart         string
+	recordLines         int
+	csv                 bool
+	key                 string
+	stdinKey            string
+	cmdKey              string
+	fieldSeparator      string
+	numeric             bool
+	tolerance           float64
+	fuzzy               int
+	showMatch           bool
+	match               string
+	join                string
+	template            string
+	changes             bool
+	decompress          bool
+	gzipOutput          bool
+	sinks               multiFlag
+	output              string
+	rotateSize          int
+	rotateInterval      int
+	color               string
+	lineNumber          bool
+	progress            bool
+	metrics             string
+	stdinEncoding       string
+	cmdEncoding         string
+	invalidBytes        string
 }
 
 func defineOptions(fs *flag.FlagSet) *options {
@@ -51,6 +181,53 @@ func defineOptions(fs *flag.FlagSet) *options {
 	patienceHelp := "wait for the specified seconds for the first received line. Use 0 for waiting forever."
 	timeoutHelp := "exit(0) after specified seconds from last received line. STDIN and command have independent timeouts. When with -f, timeout only applies to the command (not to STDIN)."
 	hardTimeoutHelp := "exit(0) after the specified seconds (or earlier). Overrides all other options."
+	indexHelp := "diffs against an index built with 'sd index' instead of running a command."
+	bloomRateHelp := "keeps a Bloom filter of the command's lines instead of the lines themselves, using a fraction of the memory. Up to the specified rate of lines (e.g. 0.001) are wrongly considered to be in the command."
+	bloomHelp := "diffs against a Bloom filter built with 'sd bloom' instead of running a command."
+	stateHelp := "adds the lines in the specified file to the command's lines, and saves the output lines (or the command's lines, with --state-source command) to it on exit, so that every run only outputs new lines."
+	stateSourceHelp := `which lines to save to the --state file: "emitted" (default) or "command".`
+	stateRetentionHelp := "drops lines from the --state file that weren't seen in the specified seconds. Use 0 for keeping them forever (default)."
+	checkpointHelp := "saves the command's lines and how many STDIN lines were processed to the specified directory periodically and on exit, to be resumed with --resume."
+	checkpointIntervalHelp := "how often to save the --checkpoint. Defaults to 60."
+	resumeHelp := "loads the command's lines from the --checkpoint and skips the STDIN lines that were already processed, which assumes that STDIN is replayed from the start (e.g. a file)."
+	zeroTerminatedHelp := "records in both streams and in the output are separated by NUL rather than newlines, like 'find -print0'."
+	stdinDelimiterHelp := `records in STDIN are separated by the specified string, which can contain the escapes \0, \n, \r, \t, \\ and \xHH.`
+	cmdDelimiterHelp := "records in the command are separated by the specified string, which can contain the same escapes as --stdin-delimiter."
+	outputDelimiterHelp := "records in the output are separated by the specified string, which can contain the same escapes as --stdin-delimiter."
+	stdinDelimiterRegexHelp := "records in STDIN are separated by matches of the specified regex."
+	cmdDelimiterRegexHelp := "records in the command are separated by matches of the specified regex."
+	maxRecordSizeHelp := "the maximum size of a record in either stream. Defaults to 65536."
+	oversizedHelp := `what to do with records longer than --max-record-size: "fail" (default), "truncate" or "skip". Truncated and skipped records are reported in STDERR.`
+	paragraphHelp := "records in both streams are paragraphs, i.e. groups of lines separated by blank lines."
+	recordStartHelp := `records in both streams start at each line that matches the specified regex, and span until the next one, e.g. '^\S' for stack traces.`
+	recordLinesHelp := "records in both streams are groups of the specified number of lines."
+	csvHelp := "both streams are CSV with a header, whose records can span several lines. The output is CSV too, with STDIN's header."
+	keyHelp := "compares only the specified comma separated columns of each record rather than the whole record. With --csv, columns are header names; otherwise they're field numbers starting at 1, e.g. '--key 2,1'."
+	stdinKeyHelp := "like --key, but only for STDIN."
+	cmdKeyHelp := "like --key, but only for the command. The columns can be in a different order than in STDIN, e.g. '--stdin-key 1,3 --cmd-key 2,1'."
+	fieldSeparatorHelp := "the separator of the fields numbered by --key. Defaults to a tab, and can contain the same escapes as --stdin-delimiter."
+	numericHelp := "compares keys (or whole records) as numbers, so that e.g. 007, 7 and 7.0 are equal."
+	toleranceHelp := "like --numeric, but numbers that differ by up to the specified delta are equal too. Can't be used with several key columns."
+	fuzzyHelp := "records are equal if their Levenshtein distance (the number of characters inserted, deleted or replaced) is up to the specified distance."
+	showMatchHelp := "with --fuzzy and --intersection, outputs each record followed by --field-separator and its closest match in the command."
+	matchHelp := `how records are compared with the command's records: "exact" (default), or treating the command's records as "glob" patterns (e.g. *.example.com), "regex" patterns (e.g. ^test_), "prefix"es or "substring"s, so records matching any of them are in the command.`
+	joinHelp := `outputs each STDIN record joined to the command's records with the same key, like a relational join that doesn't need sorted input: "inner" outputs a record per match, "left" also outputs records without matches, and "anti" only outputs records without matches. Records are joined by --field-separator unless there's a --template.`
+	templateHelp := `formats each output record with the specified Go text/template, which can use {{.Line}}, {{.Key}}, {{.Fields}}, {{.Side}} ("left", "right", "common" or "changed"), {{.Number}} (in its stream, starting at 1), {{.Match}} (with --join or --show-match), {{.Changed}} (with --changes) and {{.Time}}, and the functions join, json, sql (for MySQL) and ansisql, e.g. 'INSERT INTO users VALUES ({{sql .Line}});'.`
+	changesHelp := `compares records with the same key field by field, and outputs each record preceded by its category and --field-separator: "left" if it's only in STDIN, "right" if it's only in the command, "changed" if other fields differ (followed by the numbers of those fields), or "common" otherwise, only with --intersection. Needs --key, --stdin-key or --cmd-key.`
+	decompressHelp := "detects gzip, bzip2 and zstd compressed streams by their first bytes and decompresses them. zstd needs the zstd command."
+	gzipOutputHelp := "compresses the output with gzip."
+	sinkHelp := `sends the output records of a side ("left" if only in STDIN, "right" if only in the command, "common", "changed" or "all") to the specified file or FIFO, or to STDOUT if it's -. Can be repeated, e.g. '--sink left=missing.txt --sink right=extra.txt --sink all=-'. Defaults to all=-.`
+	outputHelp := "writes the output to the specified file rather than STDOUT, like '--sink all=%path%'. Files are written to a temporary file that's renamed when sd finishes, so they never have partial output. The path can contain %n and %t, which are replaced by the number of the file (continuing from the files of previous runs) and the time it was started."
+	rotateSizeHelp := "with --output or --sink files, starts a new file once the current one has the specified bytes of output. The path must contain %n."
+	rotateIntervalHelp := "with --output or --sink files, starts a new file once the current one has been written to for the specified seconds. The path must contain %n or %t."
+	colorHelp := `colors the output records by side: red if only in STDIN, green if only in the command, yellow if changed and dim if common. "auto" (default) colors them only when writing to a terminal, "always" or "never".`
+	lineNumberHelp := "precedes each output record with its number in its stream, starting at 1."
+	progressHelp := "shows in STDERR how many lines were read from each stream, when their timeouts fire, how many lines are in the diffee and pending to be diffed, and how many were output, updated in place."
+	metricsHelp := "serves Prometheus metrics in /metrics on the specified address, e.g. ':9100': lines read, timeout resets, lines matched and output, lines in the diffee, pending lines and goroutines."
+	stdinEncodingHelp := `converts STDIN from the specified encoding to UTF-8: "utf-8", "latin-1", "windows-1252", "utf-16" (big endian unless there's a BOM), "utf-16le" or "utf-16be".`
+	cmdEncodingHelp := "like --stdin-encoding, but for the command."
+	invalidBytesHelp := `what to do with byte sequences that aren't valid in the --stdin-encoding or --cmd-encoding: "replace" them with U+FFFD (default), "skip" them or "fail".`
+	spillHelp := "partitions both streams into the specified number of temporary files and diffs them one pair at a time, for streams that don't fit in memory. Output starts once both streams finish, so it can't be used with -f."
 
 	var o options
 	setDefaultOptions(&o)
@@ -66,6 +243,55 @@ func defineOptions(fs *flag.FlagSet) *options {
 	fs.IntVar(&o.timeoutF, "t", o.timeoutF, timeoutHelp)
 	fs.IntVar(&o.hardTimeout, "hard-timeout", o.hardTimeout, hardTimeoutHelp)
 	fs.IntVar(&o.hardTimeout, "h", o.hardTimeout, hardTimeoutHelp)
+	fs.StringVar(&o.index, "index", o.index, indexHelp)
+	fs.Float64Var(&o.bloomRate, "false-positive-rate", o.bloomRate, bloomRateHelp)
+	fs.StringVar(&o.bloom, "bloom", o.bloom, bloomHelp)
+	fs.IntVar(&o.spill, "spill", o.spill, spillHelp)
+	fs.StringVar(&o.state, "state", o.state, stateHelp)
+	fs.StringVar(&o.stateSource, "state-source", o.stateSource, stateSourceHelp)
+	fs.IntVar(&o.stateRetention, "state-retention", o.stateRetention, stateRetentionHelp)
+	fs.StringVar(&o.checkpoint, "checkpoint", o.checkpoint, checkpointHelp)
+	fs.IntVar(&o.checkpointInterval, "checkpoint-interval", o.checkpointInterval, checkpointIntervalHelp)
+	fs.BoolVar(&o.resume, "resume", o.resume, resumeHelp)
+	fs.BoolVar(&o.zeroTerminated, "zero-terminated", o.zeroTerminated, zeroTerminatedHelp)
+	fs.BoolVar(&o.zeroTerminated, "z", o.zeroTerminated, zeroTerminatedHelp)
+	fs.StringVar(&o.stdinDelimiter, "stdin-delimiter", o.stdinDelimiter, stdinDelimiterHelp)
+	fs.StringVar(&o.cmdDelimiter, "cmd-delimiter", o.cmdDelimiter, cmdDelimiterHelp)
+	fs.StringVar(&o.outputDelimiter, "output-delimiter", o.outputDelimiter, outputDelimiterHelp)
+	fs.StringVar(&o.stdinDelimiterRegex, "stdin-delimiter-regex", o.stdinDelimiterRegex, stdinDelimiterRegexHelp)
+	fs.StringVar(&o.cmdDelimiterRegex, "cmd-delimiter-regex", o.cmdDelimiterRegex, cmdDelimiterRegexHelp)
+	fs.IntVar(&o.maxRecordSize, "max-record-size", o.maxRecordSize, maxRecordSizeHelp)
+	fs.StringVar(&o.oversized, "oversized", o.oversized, oversizedHelp)
+	fs.BoolVar(&o.paragraph, "paragraph", o.paragraph, paragraphHelp)
+	fs.StringVar(&o.recordStart, "record-start", o.recordStart, recordStartHelp)
+	fs.IntVar(&o.recordLines, "record-lines", o.recordLines, recordLinesHelp)
+	fs.BoolVar(&o.csv, "csv", o.csv, csvHelp)
+	fs.StringVar(&o.key, "key", o.key, keyHelp)
+	fs.StringVar(&o.stdinKey, "stdin-key", o.stdinKey, stdinKeyHelp)
+	fs.StringVar(&o.cmdKey, "cmd-key", o.cmdKey, cmdKeyHelp)
+	fs.StringVar(&o.fieldSeparator, "field-separator", o.fieldSeparator, fieldSeparatorHelp)
+	fs.BoolVar(&o.numeric, "numeric", o.numeric, numericHelp)
+	fs.Float64Var(&o.tolerance, "tolerance", o.tolerance, toleranceHelp)
+	fs.IntVar(&o.fuzzy, "fuzzy", o.fuzzy, fuzzyHelp)
+	fs.BoolVar(&o.showMatch, "show-match", o.showMatch, showMatchHelp)
+	fs.StringVar(&o.match, "match", o.match, matchHelp)
+	fs.StringVar(&o.join, "join", o.join, joinHelp)
+	fs.StringVar(&o.template, "template", o.template, templateHelp)
+	fs.BoolVar(&o.changes, "changes", o.changes, changesHelp)
+	fs.BoolVar(&o.decompress, "decompress", o.decompress, decompressHelp)
+	fs.BoolVar(&o.gzipOutput, "gzip-output", o.gzipOutput, gzipOutputHelp)
+	fs.Var(&o.sinks, "sink", sinkHelp)
+	fs.StringVar(&o.output, "output", o.output, outputHelp)
+	fs.IntVar(&o.rotateSize, "rotate-size", o.rotateSize, rotateSizeHelp)
+	fs.IntVar(&o.rotateInterval, "rotate-interval", o.rotateInterval, rotateIntervalHelp)
+	fs.StringVar(&o.color, "color", o.color, colorHelp)
+	fs.BoolVar(&o.lineNumber, "line-number", o.lineNumber, lineNumberHelp)
+	fs.BoolVar(&o.lineNumber, "n", o.lineNumber, lineNumberHelp)
+	fs.BoolVar(&o.progress, "progress", o.progress, progressHelp)
+	fs.StringVar(&o.metrics, "metrics", o.metrics, metricsHelp)
+	fs.StringVar(&o.stdinEncoding, "stdin-encoding", o.stdinEncoding, stdinEncodingHelp)
+	fs.StringVar(&o.cmdEncoding, "cmd-encoding", o.cmdEncoding, cmdEncodingHelp)
+	fs.StringVar(&o.invalidBytes, "invalid-bytes", o.invalidBytes, invalidBytesHelp)
 
 	fs.Usage = usage
 
@@ -79,6 +305,53 @@ func setDefaultOptions(o *options) {
 	o.patience = -1
 	o.timeoutF = 10
 	o.hardTimeout = 0
+	o.index = ""
+	o.spill = 0
+	o.bloomRate = 0
+	o.bloom = ""
+	o.state = ""
+	o.stateSource = "emitted"
+	o.stateRetention = 0
+	o.checkpoint = ""
+	o.checkpointInterval = 60
+	o.resume = false
+	o.zeroTerminated = false
+	o.stdinDelimiter = ""
+	o.cmdDelimiter = ""
+	o.outputDelimiter = ""
+	o.stdinDelimiterRegex = ""
+	o.cmdDelimiterRegex = ""
+	o.maxRecordSize = bufio.MaxScanTokenSize
+	o.oversized = "fail"
+	o.paragraph = false
+	o.recordStart = ""
+	o.recordLines = 1
+	o.csv = false
+	o.key = ""
+	o.stdinKey = ""
+	o.cmdKey = ""
+	o.fieldSeparator = `\t`
+	o.numeric = false
+	o.tolerance = 0
+	o.fuzzy = 0
+	o.showMatch = false
+	o.match = "exact"
+	o.join = ""
+	o.template = ""
+	o.changes = false
+	o.decompress = false
+	o.gzipOutput = false
+	o.sinks = nil
+	o.output = ""
+	o.rotateSize = 0
+	o.rotateInterval = 0
+	o.color = "auto"
+	o.lineNumber = false
+	o.progress = false
+	o.metrics = ""
+	o.stdinEncoding = ""
+	o.cmdEncoding = ""
+	o.invalidBytes = "replace"
 }
 
 func resolveOptions(args []string) (*options, error) {
@@ -89,6 +362,183 @@ func resolveOptions(args []string) (*options, error) {
 		return o, err
 	}
 
+	if o.bloomRate < 0 || o.bloomRate >= 1 {
+		return o, errors.New("--false-positive-rate must be between 0 and 1")
+	}
+	if o.index != "" && o.bloom != "" {
+		return o, errors.New("--index can't be used with --bloom")
+	}
+	if o.stateSource != "emitted" && o.stateSource != "command" {
+		return o, errors.New(`--state-source must be "emitted" or "command"`)
+	}
+	if o.state != "" && (o.index != "" || o.spill > 0) {
+		return o, errors.New("--state can't be used with --index or --spill")
+	}
+	if o.checkpointInterval <= 0 {
+		return o, errors.New("--checkpoint-interval must be positive")
+	}
+	if o.resume && o.checkpoint == "" {
+		return o, errors.New("--resume needs a --checkpoint")
+	}
+	if o.checkpoint != "" && (o.index != "" || o.spill > 0) {
+		return o, errors.New("--checkpoint can't be used with --index or --spill")
+	}
+	for _, d := range []string{o.stdinDelimiter, o.cmdDelimiter, o.outputDelimiter} {
+		if _, err := unescape(d); err != nil {
+			return o, err
+		}
+	}
+	for _, r := range []string{o.stdinDelimiterRegex, o.cmdDelimiterRegex} {
+		if r == "" {
+			continue
+		}
+		re, err := regexp.Compile(r)
+		if err != nil {
+			return o, err
+		}
+		if re.MatchString("") {
+			return o, fmt.Errorf("delimiter regex %q can't match an empty string", r)
+		}
+	}
+	if o.maxRecordSize <= 0 {
+		return o, errors.New("--max-record-size must be positive")
+	}
+	if o.oversized != "fail" && o.oversized != "truncate" && o.oversized != "skip" {
+		return o, errors.New(`--oversized must be "fail", "truncate" or "skip"`)
+	}
+	if o.recordStart != "" {
+		if _, err := regexp.Compile(o.recordStart); err != nil {
+			return o, err
+		}
+	}
+	if o.recordLines < 1 {
+		return o, errors.New("--record-lines must be positive")
+	}
+	framings := 0
+	for _, framing := range []bool{o.paragraph, o.recordStart != "", o.recordLines > 1} {
+		if framing {
+			framings++
+		}
+	}
+	if framings > 1 {
+		return o, errors.New("only one of --paragraph, --record-start and --record-lines can be used")
+	}
+	stdinKey, cmdKey := keyColumns(o.stdinKey, o.key), keyColumns(o.cmdKey, o.key)
+	if len(stdinKey) != len(cmdKey) {
+		return o, errors.New("STDIN and the command must have the same number of key columns")
+	}
+	if (o.index != "" || o.bloom != "") && (len(stdinKey) > 0 || o.numeric || o.csv || framings > 0) {
+		return o, errors.New("--index and --bloom can't be used with --key, --stdin-key, --cmd-key, --numeric, --csv or framings, as 'sd index' and 'sd bloom' keep whole lines")
+	}
+	if !o.csv {
+		for _, k := range append(stdinKey, cmdKey...) {
+			if n, err := strconv.Atoi(k); err != nil || n < 1 {
+				return o, fmt.Errorf("key column %q must be a field number starting at 1, or a header name with --csv", k)
+			}
+		}
+	}
+	if sep, err := unescape(o.fieldSeparator); err != nil || sep == "" {
+		return o, errors.New("--field-separator must be a valid non-empty string")
+	}
+	if o.tolerance < 0 {
+		return o, errors.New("--tolerance can't be negative")
+	}
+	if o.tolerance > 0 && len(stdinKey) > 1 {
+		return o, errors.New("--tolerance can't be used with several key columns")
+	}
+	if o.tolerance > 0 && (o.index != "" || o.bloom != "" || o.bloomRate > 0 || o.spill > 0) {
+		return o, errors.New("--tolerance can't be used with --index, --bloom, --false-positive-rate or --spill")
+	}
+	if o.fuzzy < 0 {
+		return o, errors.New("--fuzzy can't be negative")
+	}
+	if o.fuzzy > 0 && (o.index != "" || o.bloom != "" || o.bloomRate > 0 || o.spill > 0 || o.tolerance > 0) {
+		return o, errors.New("--fuzzy can't be used with --index, --bloom, --false-positive-rate, --spill or --tolerance")
+	}
+	if o.showMatch && (o.fuzzy == 0 || !o.intersection) {
+		return o, errors.New("--show-match needs --fuzzy and --intersection")
+	}
+	switch o.match {
+	case "exact", "glob", "regex", "prefix", "substring":
+	default:
+		return o, errors.New(`--match must be "exact", "glob", "regex", "prefix" or "substring"`)
+	}
+	if o.match != "exact" && (o.index != "" || o.bloom != "" || o.bloomRate > 0 || o.spill > 0 || o.tolerance > 0 || o.fuzzy > 0) {
+		return o, errors.New("--match can't be used with --index, --bloom, --false-positive-rate, --spill, --tolerance or --fuzzy")
+	}
+	if o.match != "exact" && o.state != "" && o.stateSource == "emitted" {
+		return o, errors.New("--match can't be used with --state, unless --state-source is command")
+	}
+	if o.join != "" && o.join != "inner" && o.join != "left" && o.join != "anti" {
+		return o, errors.New(`--join must be "inner", "left" or "anti"`)
+	}
+	if o.join != "" && (o.intersection || o.index != "" || o.bloom != "" || o.bloomRate > 0 || o.spill > 0 || o.tolerance > 0 || o.fuzzy > 0 || o.match != "exact" || o.state != "") {
+		return o, errors.New("--join can't be used with --intersection, --index, --bloom, --false-positive-rate, --spill, --tolerance, --fuzzy, --match or --state")
+	}
+	if o.changes && len(stdinKey) == 0 {
+		return o, errors.New("--changes needs --key, --stdin-key or --cmd-key")
+	}
+	if o.changes && (o.follow || o.csv || o.index != "" || o.bloom != "" || o.bloomRate > 0 || o.spill > 0 || o.tolerance > 0 || o.fuzzy > 0 || o.match != "exact" || o.join != "" || o.state != "" || o.checkpoint != "") {
+		return o, errors.New("--changes can't be used with -f, --csv, --index, --bloom, --false-positive-rate, --spill, --tolerance, --fuzzy, --match, --join, --state or --checkpoint")
+	}
+	for _, k := range o.sinks {
+		if _, _, err := parseSink(k); err != nil {
+			return o, err
+		}
+	}
+	if o.rotateSize < 0 || o.rotateInterval < 0 {
+		return o, errors.New("--rotate-size and --rotate-interval can't be negative")
+	}
+	if o.rotateSize > 0 || o.rotateInterval > 0 {
+		files := 0
+		for _, k := range resolveSinks(o) {
+			if _, path, _ := parseSink(k); path != "-" {
+				files++
+				if !strings.Contains(path, "%n") && !strings.Contains(path, "%t") {
+					return o, fmt.Errorf("%q must contain %%n or %%t to be rotated", path)
+				}
+				// several files can be rotated by size within a second
+				if o.rotateSize > 0 && !strings.Contains(path, "%n") {
+					return o, fmt.Errorf("%q must contain %%n to be rotated by size", path)
+				}
+			}
+		}
+		if files == 0 {
+			return o, errors.New("--rotate-size and --rotate-interval need --output or --sink files")
+		}
+	}
+	if o.color != "auto" && o.color != "always" && o.color != "never" {
+		return o, errors.New(`--color must be "auto", "always" or "never"`)
+	}
+	if o.template != "" {
+		if _, err := parseTemplate(o.template); err != nil {
+			return o, err
+		}
+	}
+	if o.csv && (framings > 0 || o.zeroTerminated || o.stdinDelimiter != "" || o.cmdDelimiter != "" || o.stdinDelimiterRegex != "" || o.cmdDelimiterRegex != "") {
+		return o, errors.New("--csv can't be used with other record delimiters or framings")
+	}
+	for _, e := range []string{o.stdinEncoding, o.cmdEncoding} {
+		if e == "" {
+			continue
+		}
+		if _, err := newRuneDecoder(e); err != nil {
+			return o, err
+		}
+	}
+	if o.invalidBytes != "replace" && o.invalidBytes != "skip" && o.invalidBytes != "fail" {
+		return o, errors.New(`--invalid-bytes must be "replace", "skip" or "fail"`)
+	}
+	if o.spill < 0 {
+		return o, errors.New("--spill needs a positive number of partitions")
+	}
+	if o.spill > 0 && o.follow {
+		return o, errors.New("--spill can't be used with -f")
+	}
+	if o.spill > 0 && (o.index != "" || o.bloom != "" || o.bloomRate > 0) {
+		return o, errors.New("--spill can't be used with --index, --bloom or --false-positive-rate")
+	}
+
 	return o, nil
 }
 
@@ -135,3 +585,127 @@ func resolveTimeouts(options *options) (timeout, timeout) {
 
 	return stdinTimeout, cmdTimeout
 }
+
+func resolveStreamOptions(options *options) (streamOptions, streamOptions) {
+	var stdin, cmd streamOptions
+
+	stdin.maxRecordSize = options.maxRecordSize
+	stdin.oversized = options.oversized
+	cmd.maxRecordSize = options.maxRecordSize
+	cmd.oversized = options.oversized
+
+	stdin.paragraph = options.paragraph
+	cmd.paragraph = options.paragraph
+	if options.recordStart != "" {
+		stdin.recordStart = regexp.MustCompile(options.recordStart)
+		cmd.recordStart = regexp.MustCompile(options.recordStart)
+	}
+	stdin.recordLines = options.recordLines
+	cmd.recordLines = options.recordLines
+
+	stdin.decompress = options.decompress
+	cmd.decompress = options.decompress
+
+	stdin.encoding = options.stdinEncoding
+	stdin.invalidBytes = options.invalidBytes
+	cmd.encoding = options.cmdEncoding
+	cmd.invalidBytes = options.invalidBytes
+
+	stdinKey, cmdKey := keyColumns(options.stdinKey, options.key), keyColumns(options.cmdKey, options.key)
+	stdin.fieldSeparator, _ = unescape(options.fieldSeparator)
+	cmd.fieldSeparator = stdin.fieldSeparator
+	if options.csv {
+		stdin.csv = newCSVHeader("STDIN", stdinKey)
+		cmd.csv = newCSVHeader("the command", cmdKey)
+	} else if len(stdinKey) > 0 {
+		stdin.keyFields = fieldNumbers(stdinKey)
+		cmd.keyFields = fieldNumbers(cmdKey)
+	}
+	// the tolerance diffee parses numbers itself, and can't parse canonical
+	// fractions like 3/2
+	stdin.numeric = options.numeric && options.tolerance == 0
+	cmd.numeric = stdin.numeric
+
+	if options.zeroTerminated {
+		stdin.delimiter = "\x00"
+		cmd.delimiter = "\x00"
+	}
+
+	if options.stdinDelimiter != "" {
+		stdin.delimiter, _ = unescape(options.stdinDelimiter)
+	}
+	if options.cmdDelimiter != "" {
+		cmd.delimiter, _ = unescape(options.cmdDelimiter)
+	}
+
+	if options.stdinDelimiterRegex != "" {
+		stdin.delimiterRegex = regexp.MustCompile(options.stdinDelimiterRegex)
+	}
+	if options.cmdDelimiterRegex != "" {
+		cmd.delimiterRegex = regexp.MustCompile(options.cmdDelimiterRegex)
+	}
+
+	return stdin, cmd
+}
+
+// resolveSinks returns the --sink options and --output, or all=- if there
+// are none.
+func resolveSinks(options *options) []string {
+	sinks := options.sinks
+	if options.output != "" {
+		sinks = append(sinks, "all="+options.output)
+	}
+	if len(sinks) == 0 {
+		return []string{"all=-"}
+	}
+	return sinks
+}
+
+// resolveFormatter returns the formatter of the output, given the resolved
+// stream options.
+func resolveFormatter(options *options, stdin, cmd streamOptions) formatter {
+	f := formatter{
+		separator: stdin.fieldSeparator,
+		sides:     options.changes,
+		numbers:   options.lineNumber,
+		matches:   options.join != "" || options.showMatch,
+		stdin:     stdin,
+		cmd:       cmd,
+	}
+	if options.template != "" {
+		f.template, _ = parseTemplate(options.template)
+	}
+	return f
+}
+
+// keyColumns returns the first non-empty key as a list of columns.
+func keyColumns(keys ...string) []string {
+	for _, k := range keys {
+		if k != "" {
+			return strings.Split(k, ",")
+		}
+	}
+	return nil
+}
+
+func fieldNumbers(columns []string) []int {
+	fields := make([]int, len(columns))
+	for i, c := range columns {
+		fields[i], _ = strconv.Atoi(c)
+	}
+	return fields
+}
+
+func resolveOutputDelimiter(options *options) string {
+	if options.outputDelimiter != "" {
+		d, _ := unescape(options.outputDelimiter)
+		return d
+	}
+	if options.zeroTerminated {
+		return "\x00"
+	}
+	if options.paragraph {
+		return "\n\n"
+	}
+	return "\n"
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"reflect"
+	"regexp"
 	"testing"
 	"time"
 )
@@ -15,78 +16,141 @@ func TestResolveOptions(t *testing.T) {
 		{
 			args: []string{},
 			expected: options{
-				follow:      false,
-				infinite:    false,
-				intersection: false,
-				patience:    -1,
-				timeoutF:    10,
-				hardTimeout: 0,
+				follow:             false,
+				infinite:           false,
+				intersection:       false,
+				patience:           -1,
+				timeoutF:           10,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+				checkpointInterval: 60,
+				stateSource:        "emitted",
+				hardTimeout:        0,
 			},
 		},
 		{
 			args: []string{"-f"},
 			expected: options{
-				follow:      true,
-				infinite:    false,
-				intersection: false,
-				patience:    -1,
-				timeoutF:    10,
-				hardTimeout: 0,
+				follow:             true,
+				infinite:           false,
+				intersection:       false,
+				patience:           -1,
+				timeoutF:           10,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+				checkpointInterval: 60,
+				stateSource:        "emitted",
+				hardTimeout:        0,
 			},
 		},
 		{
 			args: []string{"-i"},
 			expected: options{
-				follow:      false,
-				infinite:    true,
-				intersection: false,
-				patience:    -1,
-				timeoutF:    10,
-				hardTimeout: 0,
+				follow:             false,
+				infinite:           true,
+				intersection:       false,
+				patience:           -1,
+				timeoutF:           10,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+				checkpointInterval: 60,
+				stateSource:        "emitted",
+				hardTimeout:        0,
 			},
 		},
 		{
 			args: []string{"-p", "0"},
 			expected: options{
-				follow:      false,
-				infinite:    false,
-				intersection: false,
-				patience:    0,
-				timeoutF:    10,
-				hardTimeout: 0,
+				follow:             false,
+				infinite:           false,
+				intersection:       false,
+				patience:           0,
+				timeoutF:           10,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+				checkpointInterval: 60,
+				stateSource:        "emitted",
+				hardTimeout:        0,
 			},
 		},
 		{
 			args: []string{"-t", "5"},
 			expected: options{
-				follow:      false,
-				infinite:    false,
-				intersection: false,
-				patience:    -1,
-				timeoutF:    5,
-				hardTimeout: 0,
+				follow:             false,
+				infinite:           false,
+				intersection:       false,
+				patience:           -1,
+				timeoutF:           5,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+				checkpointInterval: 60,
+				stateSource:        "emitted",
+				hardTimeout:        0,
 			},
 		},
 		{
 			args: []string{"-h", "120"},
 			expected: options{
-				follow:      false,
-				infinite:    false,
-				intersection: false,
-				patience:    -1,
-				timeoutF:    10,
-				hardTimeout: 120,
+				follow:             false,
+				infinite:           false,
+				intersection:       false,
+				patience:           -1,
+				timeoutF:           10,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+				checkpointInterval: 60,
+				stateSource:        "emitted",
+				hardTimeout:        120,
 			},
 		},
 		{
 			args: []string{"-i", "-f"},
 			expected: options{
-				follow:      true,
-				infinite:    true,
-				intersection: false,
-				patience:    -1,
-				timeoutF:    10,
-				hardTimeout: 0,
+				follow:             true,
+				infinite:           true,
+				intersection:       false,
+				patience:           -1,
+				timeoutF:           10,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+				checkpointInterval: 60,
+				stateSource:        "emitted",
+				hardTimeout:        0,
 			},
 		},
 		{
@@ -108,36 +172,619 @@ func TestResolveOptions(t *testing.T) {
 		{
 			args: []string{"-f", "-t", "1", "-i", "-p", "2", "-h", "3"},
 			expected: options{
-				follow:      true,
-				infinite:    true,
-				intersection: false,
-				patience:    2,
-				timeoutF:    1,
-				hardTimeout: 3,
+				follow:             true,
+				infinite:           true,
+				intersection:       false,
+				patience:           2,
+				timeoutF:           1,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+				checkpointInterval: 60,
+				stateSource:        "emitted",
+				hardTimeout:        3,
 			},
 		},
 		{
 			args: []string{"--follow", "--timeout", "1", "--infinite", "--patience", "2", "--hard-timeout", "3"},
 			expected: options{
-				follow:      true,
-				infinite:    true,
-				intersection: false,
-				patience:    2,
-				timeoutF:    1,
-				hardTimeout: 3,
+				follow:             true,
+				infinite:           true,
+				intersection:       false,
+				patience:           2,
+				timeoutF:           1,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+				checkpointInterval: 60,
+				stateSource:        "emitted",
+				hardTimeout:        3,
 			},
 		},
 		{
 			args: []string{"--intersection"},
 			expected: options{
-				follow:      false,
-				infinite:    false,
-				intersection: true,
-				patience:    -1,
-				timeoutF:    10,
-				hardTimeout: 0,
+				follow:             false,
+				infinite:           false,
+				intersection:       true,
+				patience:           -1,
+				timeoutF:           10,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+				checkpointInterval: 60,
+				stateSource:        "emitted",
+				hardTimeout:        0,
+			},
+		},
+		{
+			args: []string{"--index", "excluded.idx"},
+			expected: options{
+				patience:           -1,
+				timeoutF:           10,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+				checkpointInterval: 60,
+				stateSource:        "emitted",
+				index:              "excluded.idx",
+			},
+		},
+		{
+			args:  []string{"--index"},
+			fails: true,
+		},
+		{
+			args: []string{"--spill", "64"},
+			expected: options{
+				patience:           -1,
+				timeoutF:           10,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+				checkpointInterval: 60,
+				stateSource:        "emitted",
+				spill:              64,
+			},
+		},
+		{
+			args:  []string{"--spill", "-1"},
+			fails: true,
+		},
+		{
+			args:  []string{"--spill", "64", "-f"},
+			fails: true,
+		},
+		{
+			args: []string{"--false-positive-rate", "0.001"},
+			expected: options{
+				patience:           -1,
+				timeoutF:           10,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+				checkpointInterval: 60,
+				stateSource:        "emitted",
+				bloomRate:          0.001,
+			},
+		},
+		{
+			args:  []string{"--false-positive-rate", "1"},
+			fails: true,
+		},
+		{
+			args: []string{"--bloom", "notified.bloom"},
+			expected: options{
+				patience:           -1,
+				timeoutF:           10,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+				checkpointInterval: 60,
+				stateSource:        "emitted",
+				bloom:              "notified.bloom",
+			},
+		},
+		{
+			args:  []string{"--bloom", "notified.bloom", "--index", "notified.idx"},
+			fails: true,
+		},
+		{
+			args:  []string{"--index", "t.idx", "--key", "2", "--cmd-key", "1"},
+			fails: true,
+		},
+		{
+			args:  []string{"--bloom", "t.bloom", "--numeric"},
+			fails: true,
+		},
+		{
+			args:  []string{"--index", "t.idx", "--csv"},
+			fails: true,
+		},
+		{
+			args: []string{"--state", "seen.state", "--state-source", "command", "--state-retention", "86400"},
+			expected: options{
+				patience:           -1,
+				timeoutF:           10,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+				checkpointInterval: 60,
+				state:              "seen.state",
+				stateSource:        "command",
+				stateRetention:     86400,
+			},
+		},
+		{
+			args:  []string{"--state", "seen.state", "--state-source", "stdin"},
+			fails: true,
+		},
+		{
+			args: []string{"--checkpoint", "/var/lib/sd", "--checkpoint-interval", "10", "--resume"},
+			expected: options{
+				patience:           -1,
+				timeoutF:           10,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+				stateSource:        "emitted",
+				checkpoint:         "/var/lib/sd",
+				checkpointInterval: 10,
+				resume:             true,
+			},
+		},
+		{
+			args:  []string{"--resume"},
+			fails: true,
+		},
+		{
+			args: []string{"-z", "--stdin-delimiter", `\t`, "--cmd-delimiter-regex", `\s+`, "--output-delimiter", ","},
+			expected: options{
+				patience:           -1,
+				timeoutF:           10,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+				stateSource:        "emitted",
+				checkpointInterval: 60,
+				zeroTerminated:     true,
+				stdinDelimiter:     `\t`,
+				cmdDelimiterRegex:  `\s+`,
+				outputDelimiter:    ",",
+			},
+		},
+		{
+			args:  []string{"--stdin-delimiter", `\q`},
+			fails: true,
+		},
+		{
+			args: []string{"--max-record-size", "1048576", "--oversized", "skip"},
+			expected: options{
+				patience:           -1,
+				timeoutF:           10,
+				stateSource:        "emitted",
+				checkpointInterval: 60,
+				maxRecordSize:      1048576,
+				oversized:          "skip",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+			},
+		},
+		{
+			args:  []string{"--max-record-size", "0"},
+			fails: true,
+		},
+		{
+			args: []string{"--record-start", `^\S`},
+			expected: options{
+				patience:           -1,
+				timeoutF:           10,
+				stateSource:        "emitted",
+				checkpointInterval: 60,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordStart:        `^\S`,
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+			},
+		},
+		{
+			args:  []string{"--record-lines", "0"},
+			fails: true,
+		},
+		{
+			args:  []string{"--paragraph", "--record-lines", "4"},
+			fails: true,
+		},
+		{
+			args: []string{"--csv", "--key", "email", "--cmd-key", "user_email"},
+			expected: options{
+				patience:           -1,
+				timeoutF:           10,
+				stateSource:        "emitted",
+				checkpointInterval: 60,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+				csv:                true,
+				key:                "email",
+				cmdKey:             "user_email",
+			},
+		},
+		{
+			args: []string{"--stdin-key", "1,3", "--cmd-key", "2,1", "--field-separator", ","},
+			expected: options{
+				patience:           -1,
+				timeoutF:           10,
+				stateSource:        "emitted",
+				checkpointInterval: 60,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     ",",
+				match:              "exact",
+				color:              "auto",
+				stdinKey:           "1,3",
+				cmdKey:             "2,1",
+			},
+		},
+		{
+			args: []string{"--key", "2", "--tolerance", "0.01"},
+			expected: options{
+				patience:           -1,
+				timeoutF:           10,
+				stateSource:        "emitted",
+				checkpointInterval: 60,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+				key:                "2",
+				tolerance:          0.01,
+			},
+		},
+		{
+			args: []string{"--fuzzy", "2", "--intersection", "--show-match"},
+			expected: options{
+				patience:           -1,
+				timeoutF:           10,
+				stateSource:        "emitted",
+				checkpointInterval: 60,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+				intersection:       true,
+				fuzzy:              2,
+				showMatch:          true,
+			},
+		},
+		{
+			args:  []string{"--fuzzy", "2", "--show-match"},
+			fails: true,
+		},
+		{
+			args:  []string{"--fuzzy", "2", "--false-positive-rate", "0.01"},
+			fails: true,
+		},
+		{
+			args:  []string{"--match", "prefix", "--spill", "4"},
+			fails: true,
+		},
+		{
+			args:  []string{"--match", "glob", "--fuzzy", "1"},
+			fails: true,
+		},
+		{
+			args:  []string{"--match", "prefix", "--state", "st"},
+			fails: true,
+		},
+		{
+			args: []string{"--match", "regex", "--intersection"},
+			expected: options{
+				patience:           -1,
+				timeoutF:           10,
+				stateSource:        "emitted",
+				checkpointInterval: 60,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "regex",
+				color:              "auto",
+				intersection:       true,
+			},
+		},
+		{
+			args: []string{"--join", "left", "--key", "1", "--template", "{{.Line}} {{.Match}}"},
+			expected: options{
+				patience:           -1,
+				timeoutF:           10,
+				stateSource:        "emitted",
+				checkpointInterval: 60,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+				join:               "left",
+				key:                "1",
+				template:           "{{.Line}} {{.Match}}",
+			},
+		},
+		{
+			args: []string{"--changes", "--key", "1", "--intersection"},
+			expected: options{
+				patience:           -1,
+				timeoutF:           10,
+				stateSource:        "emitted",
+				checkpointInterval: 60,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+				changes:            true,
+				key:                "1",
+				intersection:       true,
 			},
 		},
+		{
+			args:  []string{"--changes"},
+			fails: true,
+		},
+		{
+			args:  []string{"--changes", "--key", "1", "-f"},
+			fails: true,
+		},
+		{
+			args:  []string{"--join", "outer"},
+			fails: true,
+		},
+		{
+			args:  []string{"--join", "inner", "--intersection"},
+			fails: true,
+		},
+		{
+			args: []string{"--template", "INSERT INTO users VALUES ({{sql .Line}});"},
+			expected: options{
+				patience:           -1,
+				timeoutF:           10,
+				stateSource:        "emitted",
+				checkpointInterval: 60,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+				template:           "INSERT INTO users VALUES ({{sql .Line}});",
+			},
+		},
+		{
+			args:  []string{"--template", "{{upper .Line}}"},
+			fails: true,
+		},
+		{
+			args: []string{"--sink", "left=missing.txt", "--sink", "all=-"},
+			expected: options{
+				patience:           -1,
+				timeoutF:           10,
+				stateSource:        "emitted",
+				checkpointInterval: 60,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+				sinks:              multiFlag{"left=missing.txt", "all=-"},
+			},
+		},
+		{
+			args:  []string{"--sink", "missing.txt"},
+			fails: true,
+		},
+		{
+			args: []string{"-f", "--output", "diff-%t.txt", "--rotate-interval", "3600"},
+			expected: options{
+				follow:             true,
+				patience:           -1,
+				timeoutF:           10,
+				stateSource:        "emitted",
+				checkpointInterval: 60,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+				output:             "diff-%t.txt",
+				rotateInterval:     3600,
+			},
+		},
+		{
+			args:  []string{"--output", "diff.txt", "--rotate-size", "1000"},
+			fails: true,
+		},
+		{
+			args:  []string{"--rotate-size", "1000"},
+			fails: true,
+		},
+		{
+			args:  []string{"--output", "diff-%t.txt", "--rotate-size", "1000"},
+			fails: true,
+		},
+		{
+			args: []string{"-n", "--color", "always", "--progress", "--metrics", ":9100"},
+			expected: options{
+				patience:           -1,
+				timeoutF:           10,
+				stateSource:        "emitted",
+				checkpointInterval: 60,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				invalidBytes:       "replace",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "always",
+				lineNumber:         true,
+				progress:           true,
+				metrics:            ":9100",
+			},
+		},
+		{
+			args:  []string{"--color", "sometimes"},
+			fails: true,
+		},
+		{
+			args:  []string{"--join", "inner", "--template", "{{.Line"},
+			fails: true,
+		},
+		{
+			args:  []string{"--match", "wildcard"},
+			fails: true,
+		},
+		{
+			args:  []string{"--tolerance", "-1"},
+			fails: true,
+		},
+		{
+			args:  []string{"--key", "1,2", "--tolerance", "0.5"},
+			fails: true,
+		},
+		{
+			args:  []string{"--tolerance", "0.5", "--spill", "8"},
+			fails: true,
+		},
+		{
+			args:  []string{"--key", "email"},
+			fails: true,
+		},
+		{
+			args:  []string{"--key", "0"},
+			fails: true,
+		},
+		{
+			args:  []string{"--stdin-key", "1,2", "--cmd-key", "1"},
+			fails: true,
+		},
+		{
+			args:  []string{"--key", "1", "--field-separator", ""},
+			fails: true,
+		},
+		{
+			args:  []string{"--csv", "-z"},
+			fails: true,
+		},
+		{
+			args: []string{"--stdin-encoding", "latin-1", "--cmd-encoding", "UTF-16", "--invalid-bytes", "fail"},
+			expected: options{
+				patience:           -1,
+				timeoutF:           10,
+				stateSource:        "emitted",
+				checkpointInterval: 60,
+				maxRecordSize:      65536,
+				oversized:          "fail",
+				recordLines:        1,
+				fieldSeparator:     `\t`,
+				match:              "exact",
+				color:              "auto",
+				stdinEncoding:      "latin-1",
+				cmdEncoding:        "UTF-16",
+				invalidBytes:       "fail",
+			},
+		},
+		{
+			args:  []string{"--stdin-encoding", "ebcdic"},
+			fails: true,
+		},
+		{
+			args:  []string{"--invalid-bytes", "ignore"},
+			fails: true,
+		},
+		{
+			args:  []string{"--oversized", "ignore"},
+			fails: true,
+		},
+		{
+			args:  []string{"--cmd-delimiter-regex", `(`},
+			fails: true,
+		},
+		{
+			args:  []string{"--cmd-delimiter-regex", `\s*`},
+			fails: true,
+		},
+		{
+			args:  []string{"--checkpoint", "/var/lib/sd", "--checkpoint-interval", "0"},
+			fails: true,
+		},
 	}
 
 	for _, ts := range tests {
@@ -157,6 +804,86 @@ func TestResolveOptions(t *testing.T) {
 	}
 }
 
+func TestResolveStreamOptions(t *testing.T) {
+	tests := []struct {
+		options *options
+		stdin   streamOptions
+		cmd     streamOptions
+		output  string
+	}{
+		{
+			options: &options{maxRecordSize: 1024, oversized: "truncate"},
+			stdin:   streamOptions{maxRecordSize: 1024, oversized: "truncate"},
+			cmd:     streamOptions{maxRecordSize: 1024, oversized: "truncate"},
+			output:  "\n",
+		},
+		{
+			options: &options{zeroTerminated: true},
+			stdin:   streamOptions{delimiter: "\x00"},
+			cmd:     streamOptions{delimiter: "\x00"},
+			output:  "\x00",
+		},
+		{
+			options: &options{zeroTerminated: true, cmdDelimiter: `\n`, outputDelimiter: `\r\n`},
+			stdin:   streamOptions{delimiter: "\x00"},
+			cmd:     streamOptions{delimiter: "\n"},
+			output:  "\r\n",
+		},
+		{
+			options: &options{paragraph: true, recordLines: 1},
+			stdin:   streamOptions{paragraph: true, recordLines: 1},
+			cmd:     streamOptions{paragraph: true, recordLines: 1},
+			output:  "\n\n",
+		},
+		{
+			options: &options{csv: true, key: "email", cmdKey: "user_email"},
+			stdin:   streamOptions{csv: &csvHeader{stream: "STDIN", keys: []string{"email"}}},
+			cmd:     streamOptions{csv: &csvHeader{stream: "the command", keys: []string{"user_email"}}},
+			output:  "\n",
+		},
+		{
+			options: &options{key: "2,1", cmdKey: "3,1", fieldSeparator: `\t`},
+			stdin:   streamOptions{keyFields: []int{2, 1}, fieldSeparator: "\t"},
+			cmd:     streamOptions{keyFields: []int{3, 1}, fieldSeparator: "\t"},
+			output:  "\n",
+		},
+		{
+			options: &options{numeric: true},
+			stdin:   streamOptions{numeric: true},
+			cmd:     streamOptions{numeric: true},
+			output:  "\n",
+		},
+		{
+			options: &options{stdinEncoding: "latin-1", invalidBytes: "skip"},
+			stdin:   streamOptions{encoding: "latin-1", invalidBytes: "skip"},
+			cmd:     streamOptions{invalidBytes: "skip"},
+			output:  "\n",
+		},
+		{
+			options: &options{stdinDelimiterRegex: `,+`},
+			stdin:   streamOptions{delimiterRegex: regexp.MustCompile(`,+`)},
+			output:  "\n",
+		},
+	}
+
+	for _, ts := range tests {
+		stdin, cmd := resolveStreamOptions(ts.options)
+		output := resolveOutputDelimiter(ts.options)
+
+		if !reflect.DeepEqual(stdin, ts.stdin) {
+			t.Errorf("stdin stream options resolved incorrectly: %v was not equal to %v", stdin, ts.stdin)
+		}
+
+		if !reflect.DeepEqual(cmd, ts.cmd) {
+			t.Errorf("cmd stream options resolved incorrectly: %v was not equal to %v", cmd, ts.cmd)
+		}
+
+		if output != ts.output {
+			t.Errorf("output delimiter resolved incorrectly: %q was not equal to %q", output, ts.output)
+		}
+	}
+}
+
 func TestResolveTimeouts(t *testing.T) {
 	tests := []struct {
 		options      *options
@@ -165,12 +892,12 @@ func TestResolveTimeouts(t *testing.T) {
 	}{
 		{
 			options: &options{
-				follow:      false,
-				infinite:    false,
+				follow:       false,
+				infinite:     false,
 				intersection: false,
-				patience:    -1,
-				timeoutF:    10,
-				hardTimeout: 0,
+				patience:     -1,
+				timeoutF:     10,
+				hardTimeout:  0,
 			},
 			stdinTimeout: timeout{
 				hard:              false,
@@ -189,12 +916,12 @@ func TestResolveTimeouts(t *testing.T) {
 		},
 		{
 			options: &options{
-				follow:      true,
-				infinite:    false,
+				follow:       true,
+				infinite:     false,
 				intersection: false,
-				patience:    -1,
-				timeoutF:    10,
-				hardTimeout: 0,
+				patience:     -1,
+				timeoutF:     10,
+				hardTimeout:  0,
 			},
 			stdinTimeout: timeout{
 				hard:              false,
@@ -213,12 +940,12 @@ func TestResolveTimeouts(t *testing.T) {
 		},
 		{
 			options: &options{
-				follow:      false,
-				infinite:    true,
+				follow:       false,
+				infinite:     true,
 				intersection: false,
-				patience:    -1,
-				timeoutF:    10,
-				hardTimeout: 0,
+				patience:     -1,
+				timeoutF:     10,
+				hardTimeout:  0,
 			},
 			stdinTimeout: timeout{
 				hard:              false,
@@ -237,12 +964,12 @@ func TestResolveTimeouts(t *testing.T) {
 		},
 		{
 			options: &options{
-				follow:      false,
-				infinite:    false,
+				follow:       false,
+				infinite:     false,
 				intersection: false,
-				patience:    0,
-				timeoutF:    10,
-				hardTimeout: 0,
+				patience:     0,
+				timeoutF:     10,
+				hardTimeout:  0,
 			},
 			stdinTimeout: timeout{
 				hard:              false,
@@ -261,12 +988,12 @@ func TestResolveTimeouts(t *testing.T) {
 		},
 		{
 			options: &options{
-				follow:      true,
-				infinite:    false,
+				follow:       true,
+				infinite:     false,
 				intersection: false,
-				patience:    20,
-				timeoutF:    10,
-				hardTimeout: 0,
+				patience:     20,
+				timeoutF:     10,
+				hardTimeout:  0,
 			},
 			stdinTimeout: timeout{
 				hard:              false,
@@ -285,12 +1012,12 @@ func TestResolveTimeouts(t *testing.T) {
 		},
 		{
 			options: &options{
-				follow:      false,
-				infinite:    false,
+				follow:       false,
+				infinite:     false,
 				intersection: false,
-				patience:    -1,
-				timeoutF:    10,
-				hardTimeout: 120,
+				patience:     -1,
+				timeoutF:     10,
+				hardTimeout:  120,
 			},
 			stdinTimeout: timeout{
 				hard:              true,
@@ -309,12 +1036,12 @@ func TestResolveTimeouts(t *testing.T) {
 		},
 		{
 			options: &options{
-				follow:      true,
-				infinite:    false,
+				follow:       true,
+				infinite:     false,
 				intersection: false,
-				patience:    -1,
-				timeoutF:    30,
-				hardTimeout: 0,
+				patience:     -1,
+				timeoutF:     30,
+				hardTimeout:  0,
 			},
 			stdinTimeout: timeout{
 				hard:              false,
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomically calls write with a temporary file next to path, and
+// renames it to path if write succeeds, so path is never left half written.
+// The file is synced before it's renamed, so a crash can't leave it empty.
+func writeFileAtomically(path string, write func(w io.Writer) error) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	return renameSynced(tmp, path)
+}
+
+// renameSynced syncs and closes f, renames it to path and syncs the directory
+// so the rename is persisted too.
+func renameSynced(f *os.File, path string) error {
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
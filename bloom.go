@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"hash/fnv"
+	"io"
+	"log"
+	"math"
+	"os"
+)
+
+var bloomMagic = []byte("SDBLOOM1")
+
+var errNotABloomFilter = errors.New("not an sd bloom filter file")
+
+const bloomInitialCapacity = 1 << 16
+
+// bloomFilter is a fixed size Bloom filter using double hashing.
+type bloomFilter struct {
+	k        uint64
+	capacity uint64
+	count    uint64
+	bits     []uint64
+}
+
+func newBloomFilter(capacity int, rate float64) *bloomFilter {
+	m := math.Ceil(-float64(capacity) * math.Log(rate) / (math.Ln2 * math.Ln2))
+	k := math.Max(1, math.Ceil(m/float64(capacity)*math.Ln2))
+
+	return &bloomFilter{
+		k:        uint64(k),
+		capacity: uint64(capacity),
+		bits:     make([]uint64, (uint64(m)+63)/64),
+	}
+}
+
+func (b *bloomFilter) add(h1, h2 uint64) {
+	m := uint64(len(b.bits)) * 64
+	for i := uint64(0); i < b.k; i++ {
+		bit := (h1 + i*h2) % m
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+	b.count++
+}
+
+func (b *bloomFilter) has(h1, h2 uint64) bool {
+	m := uint64(len(b.bits)) * 64
+	for i := uint64(0); i < b.k; i++ {
+		bit := (h1 + i*h2) % m
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomDiffee is a scalable Bloom filter: whenever the current filter fills
+// up, a new one with twice the capacity and half the false positive rate is
+// added, so that the overall false positive rate stays below rate without
+// knowing the number of lines in advance.
+type bloomDiffee struct {
+	rate    float64
+	filters []*bloomFilter
+}
+
+func newBloomDiffee(rate float64) *bloomDiffee {
+	return &bloomDiffee{rate: rate}
+}
+
+func bloomHashes(s string) (uint64, uint64) {
+	h := fnv.New64a()
+	io.WriteString(h, s)
+	h1 := h.Sum64()
+
+	h = fnv.New64()
+	io.WriteString(h, s)
+	h2 := h.Sum64() | 1
+
+	return h1, h2
+}
+
+func (d *bloomDiffee) add(s string) {
+	h1, h2 := bloomHashes(s)
+	if d.has(h1, h2) {
+		return
+	}
+
+	last := len(d.filters) - 1
+	if last < 0 || d.filters[last].count >= d.filters[last].capacity {
+		n := len(d.filters)
+		d.filters = append(d.filters, newBloomFilter(bloomInitialCapacity<<uint(n), d.rate/2/math.Pow(2, float64(n))))
+		last++
+	}
+
+	d.filters[last].add(h1, h2)
+}
+
+func (d *bloomDiffee) contains(s string) bool {
+	return d.has(bloomHashes(s))
+}
+
+func (d *bloomDiffee) has(h1, h2 uint64) bool {
+	for _, f := range d.filters {
+		if f.has(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *bloomDiffee) writeTo(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.Write(bloomMagic)
+	binary.Write(bw, binary.BigEndian, d.rate)
+	binary.Write(bw, binary.BigEndian, uint64(len(d.filters)))
+	for _, f := range d.filters {
+		binary.Write(bw, binary.BigEndian, []uint64{f.k, f.capacity, f.count, uint64(len(f.bits))})
+		binary.Write(bw, binary.BigEndian, f.bits)
+	}
+
+	return bw.Flush()
+}
+
+func readBloomDiffee(r io.Reader) (*bloomDiffee, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(bloomMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || !bytes.Equal(magic, bloomMagic) {
+		return nil, errNotABloomFilter
+	}
+
+	d := &bloomDiffee{}
+	var n uint64
+	if err := binary.Read(br, binary.BigEndian, &d.rate); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(br, binary.BigEndian, &n); err != nil {
+		return nil, err
+	}
+
+	for i := uint64(0); i < n; i++ {
+		header := make([]uint64, 4)
+		if err := binary.Read(br, binary.BigEndian, header); err != nil {
+			return nil, err
+		}
+		f := &bloomFilter{k: header[0], capacity: header[1], count: header[2], bits: make([]uint64, header[3])}
+		if err := binary.Read(br, binary.BigEndian, f.bits); err != nil {
+			return nil, err
+		}
+		d.filters = append(d.filters, f)
+	}
+
+	return d, nil
+}
+
+func mustOpenBloomDiffee(path string) *bloomDiffee {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	d, err := readBloomDiffee(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return d
+}
+
+// buildBloomFilter reads lines from r and writes a Bloom filter of them to
+// path, replacing it atomically.
+func buildBloomFilter(r io.Reader, path string, rate float64) error {
+	d := newBloomDiffee(rate)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		d.add(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return writeFileAtomically(path, d.writeTo)
+}
+
+func mustRunBloom(args []string) {
+	fs := flag.NewFlagSet("bloom", flag.ExitOnError)
+	rate := fs.Float64("false-positive-rate", 0.01, "the maximum rate of lines wrongly reported as present in the filter.")
+	fs.Usage = usage
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || *rate <= 0 || *rate >= 1 {
+		usage()
+		os.Exit(1)
+	}
+
+	if err := buildBloomFilter(os.Stdin, fs.Arg(0), *rate); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBloomDiffee(t *testing.T) {
+	rate := 0.01
+	d := newBloomDiffee(rate)
+	for i := 0; i < 200000; i++ {
+		d.add(strconv.Itoa(i))
+	}
+
+	for i := 0; i < 200000; i++ {
+		if !d.contains(strconv.Itoa(i)) {
+			t.Fatalf("%v was added but isn't contained", i)
+		}
+	}
+
+	falsePositives := 0
+	for i := 200000; i < 400000; i++ {
+		if d.contains(strconv.Itoa(i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > int(200000*rate) {
+		t.Errorf("had %v false positives, more than the rate of %v allows", falsePositives, rate)
+	}
+}
+
+func TestBloomDiffeeRoundTrip(t *testing.T) {
+	d := newBloomDiffee(0.001)
+	for i := 0; i < 100000; i++ {
+		d.add(strconv.Itoa(i))
+	}
+
+	var b bytes.Buffer
+	if err := d.writeTo(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := readBloomDiffee(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(d, read) {
+		t.Errorf("the read Bloom filter is not the same as the written one")
+	}
+}
+
+func TestReadBloomDiffeeRejectsOtherFiles(t *testing.T) {
+	if _, err := readBloomDiffee(bytes.NewBufferString("1\n2\n3\n4\n5\n6\n7\n8\n9\n")); err != errNotABloomFilter {
+		t.Errorf("should have failed with %v, but failed with %v", errNotABloomFilter, err)
+	}
+}
+
+func TestDiffWithBloomDiffee(t *testing.T) {
+	stdout := make(chan result)
+	reader := cmdToReader(`echo -e "1\n2\n3\n4\n5"`)
+
+	go diff(`echo -e "2\n4"`, newBloomDiffee(0.0001), defaultTimeout(), defaultTimeout(), stdout, mockUtils{reader}, false, nil)
+
+	lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{}.format)
+
+	if reflect.DeepEqual(lines, []string{"1", "3", "5"}) != true {
+		t.Errorf("result wasn't ['1', '3', '5'], it was %v", lines)
+	}
+}
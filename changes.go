@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// changesDiffee keeps the command's records by key, so that STDIN records can
+// be reported as only in STDIN, changed or common, and the remaining command
+// records as only in the command. It contains every record, so that they all
+// get to reportChanges.
+type changesDiffee struct {
+	stdin    streamOptions
+	cmd      streamOptions
+	stdinKey keyer
+	cmdKey   keyer
+	lines    map[string]string
+	numbers  map[string]int
+	keys     []string
+	n        int
+}
+
+func newChangesDiffee(stdin, cmd streamOptions) *changesDiffee {
+	return &changesDiffee{stdin: stdin, cmd: cmd, stdinKey: stdin.key(), cmdKey: cmd.key(), lines: make(map[string]string), numbers: make(map[string]int)}
+}
+
+func (d *changesDiffee) add(s string) {
+	d.n++
+	k := d.cmdKey(s)
+	if _, ok := d.lines[k]; !ok {
+		d.lines[k] = s
+		d.numbers[k] = d.n
+		d.keys = append(d.keys, k)
+	}
+}
+
+func (d *changesDiffee) contains(s string) bool {
+	return true
+}
+
+// changedFields returns the numbers of the STDIN fields that differ from the
+// command's, comparing the fields that aren't key fields in order. Fields
+// that are only in the command are numbered as if they followed STDIN's.
+func (d *changesDiffee) changedFields(stdinLine, cmdLine string) []string {
+	stdinFields, stdinNumbers := otherFields(stdinLine, d.stdin)
+	cmdFields, _ := otherFields(cmdLine, d.cmd)
+
+	var changed []string
+	n := 0
+	for i := 0; i < len(stdinFields) || i < len(cmdFields); i++ {
+		if i < len(stdinNumbers) {
+			n = stdinNumbers[i]
+		} else {
+			n++
+		}
+		if i >= len(stdinFields) || i >= len(cmdFields) || stdinFields[i] != cmdFields[i] {
+			changed = append(changed, strconv.Itoa(n))
+		}
+	}
+	return changed
+}
+
+// otherFields returns the fields of line that aren't key fields, and their
+// numbers.
+func otherFields(line string, so streamOptions) ([]string, []int) {
+	var fields []string
+	var numbers []int
+	for i, f := range strings.Split(line, so.fieldSeparator) {
+		if indexOfInt(so.keyFields, i+1) < 0 {
+			fields = append(fields, f)
+			numbers = append(numbers, i+1)
+		}
+	}
+	return fields, numbers
+}
+
+func indexOfInt(is []int, i int) int {
+	for j, v := range is {
+		if v == i {
+			return j
+		}
+	}
+	return -1
+}
+
+// reportChanges sets the side of each STDIN result: "left" if its key isn't
+// in the command, "changed" if it is but other fields differ (setting the
+// numbers of those fields), or "common" otherwise, only output if common is
+// set. Once STDIN finishes, it outputs the command's records whose keys
+// weren't in STDIN as "right".
+func reportChanges(stdout chan result, d *changesDiffee, common bool) chan result {
+	results := make(chan result)
+	go func() {
+		seen := make(map[string]bool)
+		for r := range results {
+			k := d.stdinKey(r.line)
+			cmdLine, ok := d.lines[k]
+			if !ok {
+				r.side = "left"
+				stdout <- r
+				continue
+			}
+
+			seen[k] = true
+			if r.changed = d.changedFields(r.line, cmdLine); len(r.changed) > 0 {
+				r.side = "changed"
+				stdout <- r
+			} else if common {
+				r.side = "common"
+				stdout <- r
+			}
+		}
+
+		for _, k := range d.keys {
+			if !seen[k] {
+				stdout <- result{line: d.lines[k], side: "right", n: d.numbers[k]}
+			}
+		}
+		close(stdout)
+	}()
+
+	return results
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChangedFields(t *testing.T) {
+	d := newChangesDiffee(
+		streamOptions{keyFields: []int{2}, fieldSeparator: ","},
+		streamOptions{keyFields: []int{1}, fieldSeparator: ","},
+	)
+
+	tests := []struct {
+		stdin    string
+		cmd      string
+		expected []string
+	}{
+		{"John,1,admin", "1,John,admin", nil},
+		{"John,1,admin", "1,Jon,admin", []string{"1"}},
+		{"John,1,admin", "1,John,user,x", []string{"3", "4"}},
+		{"John,1,admin,x", "1,John", []string{"3", "4"}},
+	}
+
+	for _, ts := range tests {
+		if changed := d.changedFields(ts.stdin, ts.cmd); !reflect.DeepEqual(changed, ts.expected) {
+			t.Errorf("changed fields of %q and %q weren't %v, they were %v", ts.stdin, ts.cmd, ts.expected, changed)
+		}
+	}
+}
+
+func TestReportChanges(t *testing.T) {
+	stdin := streamOptions{keyFields: []int{1}, fieldSeparator: "\t"}
+	cmd := streamOptions{keyFields: []int{1}, fieldSeparator: "\t"}
+
+	for _, common := range []bool{false, true} {
+		stdout := make(chan result)
+		reader := strings.NewReader("1\tjohn\tadmin\n2\tjane\tuser\n3\tjim\tuser\n")
+		d := newChangesDiffee(stdin, cmd)
+
+		go diff(`echo -e "1\tjohn\tuser\n3\tjim\tuser\n4\tjoe\tuser"`, d, defaultTimeout(), defaultTimeout(), reportChanges(stdout, d, common), mockUtils{reader}, true, nil)
+
+		lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{separator: "\t", sides: true}.format)
+
+		expected := []string{"changed\t1\tjohn\tadmin\t3", "left\t2\tjane\tuser", "right\t4\tjoe\tuser"}
+		if common {
+			expected = []string{"changed\t1\tjohn\tadmin\t3", "common\t3\tjim\tuser", "left\t2\tjane\tuser", "right\t4\tjoe\tuser"}
+		}
+		if reflect.DeepEqual(lines, expected) != true {
+			t.Errorf("result wasn't %q, it was %q", expected, lines)
+		}
+	}
+}
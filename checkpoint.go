@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// checkpoint periodically saves the command's lines and how many STDIN lines
+// have been processed to a directory, so that a session can be resumed after
+// a restart. The command's lines are appended to dir/command as they arrive;
+// dir/stdin holds the number of STDIN lines processed, which is only updated
+// once every line up to it has been diffed (and written to the output, if it
+// had to be).
+type checkpoint struct {
+	sync.Mutex
+	dir     string
+	command *os.File
+	w       *bufio.Writer
+	loaded  map[string]int
+
+	skip    int
+	next    int
+	handled map[int]bool
+}
+
+// openCheckpoint starts a new checkpoint in dir, or continues the one in it
+// if resume is set, in which case its command lines are added to d. As the
+// command is run again, its lines are only recorded once they're not among
+// the loaded ones, so dir/command doesn't grow on every resume.
+func openCheckpoint(dir string, resume bool, d diffee) (*checkpoint, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	c := &checkpoint{dir: dir, handled: make(map[int]bool), loaded: make(map[string]int)}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resume {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		lines, err := c.load()
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			d.add(line)
+			c.loaded[line]++
+		}
+		// rewritten, as its last line might have been cut short by a crash
+		err = writeFileAtomically(filepath.Join(dir, "command"), func(w io.Writer) error {
+			bw := bufio.NewWriter(w)
+			for _, line := range lines {
+				bw.WriteString(strconv.Quote(line) + "\n")
+			}
+			return bw.Flush()
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, "command"), flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	c.command = f
+	c.w = bufio.NewWriter(f)
+
+	return c, nil
+}
+
+func mustOpenCheckpoint(dir string, resume bool, d diffee) *checkpoint {
+	c, err := openCheckpoint(dir, resume, d)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return c
+}
+
+// load reads the number of STDIN lines processed, and returns the command's
+// lines.
+func (c *checkpoint) load() ([]string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(c.dir, "stdin"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if c.skip, err = strconv.Atoi(strings.TrimSpace(string(b))); err != nil {
+			return nil, fmt.Errorf("%v: malformed checkpoint", filepath.Join(c.dir, "stdin"))
+		}
+		c.next = c.skip
+	}
+
+	f, err := os.Open(filepath.Join(c.dir, "command"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<30)
+	for scanner.Scan() {
+		line, err := strconv.Unquote(scanner.Text())
+		if err != nil {
+			// the last line might have been cut short by a crash
+			break
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, scanner.Err()
+}
+
+// record appends line to dir/command, unless it's one of the loaded lines,
+// and returns whether it did.
+func (c *checkpoint) record(line string) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if n := c.loaded[line]; n > 0 {
+		if n == 1 {
+			delete(c.loaded, line)
+		} else {
+			c.loaded[line] = n - 1
+		}
+		return false
+	}
+	c.w.WriteString(strconv.Quote(line) + "\n")
+	return true
+}
+
+// skipped tells if the nth STDIN line (starting at 0) was processed before
+// resuming.
+func (c *checkpoint) skipped(n int) bool {
+	return c != nil && n < c.skip
+}
+
+// done marks the nth STDIN line (starting at 0) as processed. Lines output
+// several times, e.g. by --join, are done more than once.
+func (c *checkpoint) done(n int) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	if n >= c.next {
+		c.handled[n] = true
+	}
+	for c.handled[c.next] {
+		delete(c.handled, c.next)
+		c.next++
+	}
+	c.Unlock()
+}
+
+func (c *checkpoint) save() error {
+	c.Lock()
+	defer c.Unlock()
+
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+	if err := c.command.Sync(); err != nil {
+		return err
+	}
+
+	return writeFileAtomically(filepath.Join(c.dir, "stdin"), func(w io.Writer) error {
+		_, err := fmt.Fprintln(w, c.next)
+		return err
+	})
+}
+
+func (c *checkpoint) mustSave() {
+	if err := c.save(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (c *checkpoint) saveEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		c.mustSave()
+	}
+}
+
+// checkpointDiffee records every line added to it in the checkpoint, and
+// skips those that were loaded from it.
+type checkpointDiffee struct {
+	diffee
+	checkpoint *checkpoint
+}
+
+func (d checkpointDiffee) add(line string) {
+	if d.checkpoint.record(line) {
+		d.diffee.add(line)
+	}
+}
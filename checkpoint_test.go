@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCheckpointDoneOnlyAdvancesOverContiguousLines(t *testing.T) {
+	c := &checkpoint{handled: make(map[int]bool)}
+
+	tests := []struct {
+		done     int
+		expected int
+	}{
+		{1, 0},
+		{2, 0},
+		{0, 3},
+		{5, 3},
+		{3, 4},
+		{4, 6},
+	}
+
+	for _, ts := range tests {
+		c.done(ts.done)
+		if c.next != ts.expected {
+			t.Errorf("after line %v was done, progress should have been %v but was %v", ts.done, ts.expected, c.next)
+		}
+	}
+}
+
+func TestCheckpointResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	runs := []struct {
+		cmd      string
+		stdin    string
+		resume   bool
+		expected []string
+	}{
+		{`echo -e "2\n4"`, `echo -e "1\n2\n3"`, false, []string{"1", "3"}},
+		{`echo "6"`, `echo -e "1\n2\n3\n4\n5\n6\n7"`, true, []string{"5", "7"}},
+		{`echo "6"`, `echo -e "1\n2\n3\n4"`, false, []string{"1", "2", "3", "4"}},
+	}
+
+	for _, run := range runs {
+		d := &listDiffee{}
+		cp, err := openCheckpoint(dir, run.resume, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stdout := make(chan result)
+		go diff(run.cmd, checkpointDiffee{d, cp}, defaultTimeout(), defaultTimeout(), stdout, mockUtils{cmdToReader(run.stdin)}, false, cp)
+
+		lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{}.format)
+		if reflect.DeepEqual(lines, run.expected) != true {
+			t.Errorf("result wasn't %v, it was %v", run.expected, lines)
+		}
+		if err := cp.save(); err != nil {
+			t.Fatal(err)
+		}
+		cp.command.Close()
+	}
+}
+
+func TestCheckpointDoesntGrowOnResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 4; i++ {
+		d := &listDiffee{}
+		cp, err := openCheckpoint(dir, i > 0, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stdout := make(chan result)
+		go diff(`echo -e "2\n4\n4"`, checkpointDiffee{d, cp}, defaultTimeout(), defaultTimeout(), stdout, mockUtils{cmdToReader(`echo 1`)}, false, cp)
+		readResultsAndSortBlocking(stdout, 1*time.Second, formatter{}.format)
+		if err := cp.save(); err != nil {
+			t.Fatal(err)
+		}
+		cp.command.Close()
+
+		if !reflect.DeepEqual([]string(*d), []string{"2", "4", "4"}) {
+			t.Errorf("the command's lines weren't [2 4 4] on run %v, they were %v", i, *d)
+		}
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "command"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "\"2\"\n\"4\"\n\"4\"\n" {
+		t.Errorf("the checkpoint's command lines should have been recorded once, but they were %q", b)
+	}
+}
+
+func TestCheckpointOnlyDoneOnceWritten(t *testing.T) {
+	d := &listDiffee{}
+	cp := &checkpoint{handled: make(map[int]bool)}
+	stdout := make(chan result)
+	go diff(``, d, defaultTimeout(), defaultTimeout(), stdout, mockUtils{cmdToReader(`echo -e "1\n2"`)}, false, cp)
+
+	var results []result
+	for r := range stdout {
+		results = append(results, r)
+	}
+	if cp.next != 0 {
+		t.Errorf("no line should have been done before being written, but %v were", cp.next)
+	}
+
+	for _, r := range results {
+		r.done()
+	}
+	if cp.next != 2 {
+		t.Errorf("2 lines should have been done once written, but %v were", cp.next)
+	}
+}
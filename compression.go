@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os/exec"
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+	// bzip2 streams start with a block, or end right away if they're empty
+	bzip2BlockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	bzip2EndMagic   = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+)
+
+var errNoZstd = errors.New("reading zstd compressed streams needs the zstd command")
+
+// decompress detects gzip, bzip2 and zstd compressed streams by their magic
+// bytes and returns a reader of their contents; other streams are returned
+// as they are. As Go doesn't have a zstd decompressor, zstd streams are
+// piped through the zstd command.
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(10)
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case isBzip2(magic):
+		return bzip2.NewReader(br), nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		return zstdReader(br)
+	default:
+		return br, nil
+	}
+}
+
+// isBzip2 checks the block size and the magic of the first block too, as
+// "BZh" alone is common in text.
+func isBzip2(magic []byte) bool {
+	if len(magic) < 10 || !bytes.HasPrefix(magic, bzip2Magic) || magic[3] < '1' || magic[3] > '9' {
+		return false
+	}
+	return bytes.Equal(magic[4:], bzip2BlockMagic) || bytes.Equal(magic[4:], bzip2EndMagic)
+}
+
+func zstdReader(r io.Reader) (io.Reader, error) {
+	path, err := exec.LookPath("zstd")
+	if err != nil {
+		return nil, errNoZstd
+	}
+
+	cmd := exec.Command(path, "-dcq")
+	cmd.Stdin = r
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &cmdReader{stdout, cmd}, nil
+}
+
+// cmdReader reads a command's output, and waits for the command once it ends
+// to report its errors.
+type cmdReader struct {
+	io.Reader
+	cmd *exec.Cmd
+}
+
+func (r *cmdReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		if werr := r.cmd.Wait(); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// flushWriter is implemented by writers that buffer, like gzip.Writer.
+type flushWriter interface {
+	Flush() error
+}
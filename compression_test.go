@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecompress(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		tool string
+	}{
+		{`seq 3`, ""},
+		{`seq 3 | gzip`, "gzip"},
+		{`seq 3 | bzip2`, "bzip2"},
+		{`seq 3 | zstd -q`, "zstd"},
+	}
+
+	for _, ts := range tests {
+		if _, err := exec.LookPath(ts.tool); ts.tool != "" && err != nil {
+			t.Logf("skipping %v as it isn't installed", ts.tool)
+			continue
+		}
+
+		r, err := decompress(cmdToReader(ts.cmd))
+		if err != nil {
+			t.Errorf("decompressing `%v` failed: %v", ts.cmd, err)
+			continue
+		}
+
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Errorf("reading `%v` failed: %v", ts.cmd, err)
+		}
+		if string(b) != "1\n2\n3\n" {
+			t.Errorf("`%v` should have been decompressed to '1\\n2\\n3\\n', but it was %q", ts.cmd, b)
+		}
+	}
+}
+
+func TestDecompressShortStream(t *testing.T) {
+	r, err := decompress(strings.NewReader("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := ioutil.ReadAll(r)
+	if string(b) != "1" {
+		t.Errorf("'1' should have been read as it is, but it was %q", b)
+	}
+}
+
+func TestDecompressTextLikeBzip2(t *testing.T) {
+	for _, text := range []string{"BZhello\n", "BZh9 is a block size\n"} {
+		r, err := decompress(strings.NewReader(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := ioutil.ReadAll(r)
+		if err != nil || string(b) != text {
+			t.Errorf("%q should have been read as it is, but it was %q (%v)", text, b, err)
+		}
+	}
+}
+
+func TestDiffCompressedStreams(t *testing.T) {
+	utils := mockStreamUtils{
+		i:     cmdToReader(`echo -e "1\n2\n3\n4" | gzip`),
+		stdin: streamOptions{decompress: true},
+		cmd:   streamOptions{decompress: true},
+	}
+
+	stdout := make(chan result)
+	go diff(`echo -e "1\n3" | gzip`, &listDiffee{}, defaultTimeout(), defaultTimeout(), stdout, utils, false, nil)
+
+	lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{}.format)
+
+	if reflect.DeepEqual(lines, []string{"2", "4"}) != true {
+		t.Errorf("result wasn't ['2', '4'], it was %v", lines)
+	}
+}
+
+func TestPrintLnGzipped(t *testing.T) {
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	stdout := make(chan result)
+	done := make(chan struct{})
+
+	go printLn([]sink{{side: "all", w: gz}}, stdout, nil, done, "\n", formatter{}.format)
+	stdout <- result{line: "1"}
+	close(stdout)
+	<-done
+
+	r, err := gzip.NewReader(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := make([]byte, 2)
+	if _, err := r.Read(line); err != nil || string(line) != "1\n" {
+		t.Errorf("'1\\n' should have been flushed without closing the writer, but got %q", line)
+	}
+}
+
+func TestPrintLnGzippedIsCompact(t *testing.T) {
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	stdout := make(chan result)
+	done := make(chan struct{})
+
+	go printLn([]sink{{side: "all", w: gz}}, stdout, nil, done, "\n", formatter{}.format)
+	for i := 1; i <= 200; i++ {
+		stdout <- result{line: strconv.Itoa(i)}
+	}
+	close(stdout)
+	<-done
+	gz.Close()
+
+	if b.Len() > 600 {
+		t.Errorf("200 records should have been compressed to less than 600 bytes, but they took %v", b.Len())
+	}
+}
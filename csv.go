@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// csvHeader is the header of a CSV stream. It's set by scanCSV before any
+// record is sent, and it's used to find the columns that make up the key of
+// each record.
+type csvHeader struct {
+	stream  string
+	keys    []string
+	columns []string
+	indexes []int
+}
+
+func newCSVHeader(stream string, keys []string) *csvHeader {
+	return &csvHeader{stream: stream, keys: keys}
+}
+
+func (h *csvHeader) set(columns []string) error {
+	h.columns = columns
+	h.indexes = nil
+	for _, k := range h.keys {
+		i := indexOf(columns, k)
+		if i < 0 {
+			return fmt.Errorf("there's no %q column in %v's header", k, h.stream)
+		}
+		h.indexes = append(h.indexes, i)
+	}
+
+	return nil
+}
+
+func indexOf(ss []string, s string) int {
+	for i, v := range ss {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// key returns the key columns of record, or the whole record if there are no
+// key columns.
+func (h *csvHeader) key(record string) string {
+	if len(h.indexes) == 0 {
+		return record
+	}
+
+	fields, err := csv.NewReader(strings.NewReader(record)).Read()
+	if err != nil {
+		return record
+	}
+
+	key := make([]string, len(h.indexes))
+	for i, j := range h.indexes {
+		if j < len(fields) {
+			key[i] = fields[j]
+		}
+	}
+	return strings.Join(key, "\x1f")
+}
+
+func (h *csvHeader) String() string {
+	return encodeCSV(h.columns)
+}
+
+func encodeCSV(fields []string) string {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Write(fields)
+	w.Flush()
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// scanCSV sends every record in r after the header to records, as a single
+// line of CSV that might contain quoted newlines.
+func scanCSV(r io.Reader, h *csvHeader, records chan string) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := h.set(header); err != nil {
+		log.Fatal(err)
+	}
+
+	for {
+		fields, err := cr.Read()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		records <- encodeCSV(fields)
+	}
+}
+
+// prependCSVHeader outputs the header of STDIN before the first record, or on
+// its own if there are no records.
+func prependCSVHeader(stdout chan result, h *csvHeader) chan result {
+	records := make(chan result)
+	go func() {
+		printed := false
+		for record := range records {
+			if !printed {
+				stdout <- result{line: h.String()}
+				printed = true
+			}
+			stdout <- record
+		}
+		if !printed && h.columns != nil {
+			stdout <- result{line: h.String()}
+		}
+		close(stdout)
+	}()
+
+	return records
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScanCSV(t *testing.T) {
+	input := "name,email\n\"Doe, John\",john@example.com\n\"Multi\nline\",\"quoted \"\"email\"\"\"\nplain,plain@example.com\n"
+	h := newCSVHeader("STDIN", []string{"email"})
+
+	to := make(chan string, 10)
+	scanToChannel(strings.NewReader(input), to, make(chan struct{}), streamOptions{csv: h})
+
+	records := []string{}
+	keys := []string{}
+	for record := range to {
+		records = append(records, record)
+		keys = append(keys, h.key(record))
+	}
+
+	if !reflect.DeepEqual(h.columns, []string{"name", "email"}) {
+		t.Errorf("header wasn't ['name', 'email'], it was %q", h.columns)
+	}
+
+	expected := []string{"\"Doe, John\",john@example.com", "\"Multi\nline\",\"quoted \"\"email\"\"\"", "plain,plain@example.com"}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("records weren't %q, they were %q", expected, records)
+	}
+
+	expected = []string{"john@example.com", `quoted "email"`, "plain@example.com"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("keys weren't %q, they were %q", expected, keys)
+	}
+}
+
+func TestCSVHeaderFailsOnMissingKeyColumn(t *testing.T) {
+	h := newCSVHeader("STDIN", []string{"email"})
+
+	if err := h.set([]string{"name", "mail"}); err == nil {
+		t.Errorf("setting a header without the key column should have failed")
+	}
+}
+
+func TestDiffCSVByKey(t *testing.T) {
+	stdin := newCSVHeader("STDIN", []string{"email"})
+	cmd := newCSVHeader("the command", []string{"address"})
+	utils := mockStreamUtils{
+		i:     strings.NewReader("name,email\nJohn,john@example.com\n\"Doe,\nJane\",jane@example.com\nJim,jim@example.com\n"),
+		stdin: streamOptions{csv: stdin},
+		cmd:   streamOptions{csv: cmd},
+	}
+
+	stdout := make(chan result)
+	d := keyedDiffee{&listDiffee{}, stdin.key, cmd.key}
+	go diff(`echo -e "address,unsubscribed\njohn@example.com,yes\njim@example.com,yes"`, d, defaultTimeout(), defaultTimeout(), prependCSVHeader(stdout, stdin), utils, false, nil)
+
+	lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{}.format)
+
+	expected := []string{"\"Doe,\nJane\",jane@example.com", "name,email"}
+	if reflect.DeepEqual(lines, expected) != true {
+		t.Errorf("result wasn't %q, it was %q", expected, lines)
+	}
+}
+
+func TestPrependCSVHeader(t *testing.T) {
+	h := &csvHeader{columns: []string{"a", "b"}}
+
+	stdout := make(chan result, 10)
+	records := prependCSVHeader(stdout, h)
+	close(records)
+
+	lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{}.format)
+	if reflect.DeepEqual(lines, []string{"a,b"}) != true {
+		t.Errorf("the header should be output even without records, but the output was %q", lines)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import "strings"
+
+// diffee is the set of COMMAND lines that STDIN lines are diffed against.
+// Lines are added while COMMAND is being read, and only queried once it has
+// finished loading.
+type diffee interface {
+	add(s string)
+	contains(s string) bool
+}
+
+// listDiffee compares each line against every loaded line.
+type listDiffee []string
+
+func (d *listDiffee) add(s string) {
+	*d = append(*d, s)
+}
+
+func (d *listDiffee) contains(s string) bool {
+	for _, w := range *d {
+		if s == w {
+			return true
+		}
+	}
+	return false
+}
+
+// keyer returns the part of a line that's compared.
+type keyer func(line string) string
+
+func wholeLine(line string) string {
+	return line
+}
+
+// fieldsKey returns a keyer of the specified fields (starting at 1) of lines
+// split by separator. Missing fields are empty.
+func fieldsKey(fields []int, separator string) keyer {
+	return func(line string) string {
+		split := strings.Split(line, separator)
+		key := make([]string, len(fields))
+		for i, f := range fields {
+			if f <= len(split) {
+				key[i] = split[f-1]
+			}
+		}
+		return strings.Join(key, "\x1f")
+	}
+}
+
+// keyedDiffee compares the keys of lines rather than the whole lines.
+type keyedDiffee struct {
+	diffee
+	stdinKey keyer
+	cmdKey   keyer
+}
+
+func (d keyedDiffee) add(s string) {
+	d.diffee.add(d.cmdKey(s))
+}
+
+func (d keyedDiffee) contains(s string) bool {
+	return d.diffee.contains(d.stdinKey(s))
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	invalidRune = -1
+	skipRune    = -2
+)
+
+// runeDecoder decodes the first rune in p, returning its size in bytes, or 0
+// if more bytes are needed. Invalid sequences are returned as invalidRune,
+// and sequences without a rune (like BOMs) as skipRune.
+type runeDecoder interface {
+	decode(p []byte, atEOF bool) (rune, int)
+}
+
+func newRuneDecoder(encoding string) (runeDecoder, error) {
+	switch strings.ToLower(encoding) {
+	case "utf-8", "utf8":
+		return utf8Decoder{}, nil
+	case "latin-1", "latin1", "iso-8859-1":
+		return latin1Decoder{}, nil
+	case "windows-1252", "cp1252":
+		return windows1252Decoder{}, nil
+	case "utf-16", "utf16":
+		return &utf16Decoder{detectBOM: true, order: binary.BigEndian}, nil
+	case "utf-16le", "utf16le":
+		return &utf16Decoder{order: binary.LittleEndian}, nil
+	case "utf-16be", "utf16be":
+		return &utf16Decoder{order: binary.BigEndian}, nil
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", encoding)
+	}
+}
+
+type utf8Decoder struct{}
+
+func (utf8Decoder) decode(p []byte, atEOF bool) (rune, int) {
+	if !atEOF && !utf8.FullRune(p) {
+		return 0, 0
+	}
+	r, size := utf8.DecodeRune(p)
+	if r == utf8.RuneError && size <= 1 {
+		return invalidRune, 1
+	}
+	return r, size
+}
+
+type latin1Decoder struct{}
+
+func (latin1Decoder) decode(p []byte, atEOF bool) (rune, int) {
+	return rune(p[0]), 1
+}
+
+// windows1252 maps 0x80-0x9F, where Windows-1252 differs from Latin-1. The
+// zeros are undefined.
+var windows1252 = [32]rune{
+	0x20AC, 0, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0, 0x017D, 0,
+	0, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0, 0x017E, 0x0178,
+}
+
+type windows1252Decoder struct{}
+
+func (windows1252Decoder) decode(p []byte, atEOF bool) (rune, int) {
+	if p[0] < 0x80 || p[0] > 0x9F {
+		return rune(p[0]), 1
+	}
+	if r := windows1252[p[0]-0x80]; r != 0 {
+		return r, 1
+	}
+	return invalidRune, 1
+}
+
+// utf16Decoder decodes UTF-16 in the specified byte order, skipping a BOM at
+// the start. If detectBOM is set, the BOM sets the byte order.
+type utf16Decoder struct {
+	detectBOM bool
+	order     binary.ByteOrder
+	started   bool
+}
+
+func (d *utf16Decoder) decode(p []byte, atEOF bool) (rune, int) {
+	if len(p) < 2 {
+		if atEOF {
+			return invalidRune, len(p)
+		}
+		return 0, 0
+	}
+
+	if !d.started {
+		d.started = true
+		switch {
+		case p[0] == 0xFE && p[1] == 0xFF && (d.detectBOM || d.order == binary.BigEndian):
+			d.order = binary.BigEndian
+			return skipRune, 2
+		case p[0] == 0xFF && p[1] == 0xFE && (d.detectBOM || d.order == binary.LittleEndian):
+			d.order = binary.LittleEndian
+			return skipRune, 2
+		}
+	}
+
+	u := rune(d.order.Uint16(p))
+	if !utf16.IsSurrogate(u) {
+		return u, 2
+	}
+	if u >= 0xDC00 {
+		return invalidRune, 2
+	}
+
+	if len(p) < 4 {
+		if atEOF {
+			return invalidRune, len(p)
+		}
+		return 0, 0
+	}
+	if r := utf16.DecodeRune(u, rune(d.order.Uint16(p[2:]))); r != utf8.RuneError {
+		return r, 4
+	}
+	return invalidRune, 2
+}
+
+// decodingReader converts what it reads from r to UTF-8. Invalid sequences
+// are replaced by U+FFFD, skipped, or fail reading, depending on invalid
+// being "replace", "skip" or "fail".
+type decodingReader struct {
+	r       io.Reader
+	decoder runeDecoder
+	invalid string
+
+	in  []byte
+	out bytes.Buffer
+	err error
+}
+
+func newDecodingReader(r io.Reader, encoding, invalid string) (io.Reader, error) {
+	decoder, err := newRuneDecoder(encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decodingReader{r: r, decoder: decoder, invalid: invalid}, nil
+}
+
+func (d *decodingReader) Read(p []byte) (int, error) {
+	for d.out.Len() == 0 && d.err == nil {
+		buf := make([]byte, 4096)
+		n, err := d.r.Read(buf)
+		d.in = append(d.in, buf[:n]...)
+		d.err = err
+		if err := d.decodeIn(d.err != nil); err != nil {
+			d.err = err
+		}
+	}
+
+	if d.out.Len() > 0 {
+		return d.out.Read(p)
+	}
+	return 0, d.err
+}
+
+func (d *decodingReader) decodeIn(atEOF bool) error {
+	for len(d.in) > 0 {
+		r, size := d.decoder.decode(d.in, atEOF)
+		if size == 0 {
+			break
+		}
+
+		switch {
+		case r == invalidRune && d.invalid == "fail":
+			return fmt.Errorf("found an invalid byte sequence % x", d.in[:size])
+		case r == invalidRune && d.invalid == "replace":
+			d.out.WriteRune(utf8.RuneError)
+		case r >= 0:
+			d.out.WriteRune(r)
+		}
+		d.in = d.in[size:]
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestDecodingReader(t *testing.T) {
+	tests := []struct {
+		encoding string
+		invalid  string
+		input    []byte
+		expected string
+		fails    bool
+	}{
+		{"latin-1", "replace", []byte("Jos\xe9 M\xfcller"), "José Müller", false},
+		{"windows-1252", "replace", []byte("\x93quoted\x94 \x80 \xe9"), "“quoted” € é", false},
+		{"windows-1252", "replace", []byte("a\x81b"), "a�b", false},
+		{"windows-1252", "skip", []byte("a\x81b"), "ab", false},
+		{"windows-1252", "fail", []byte("a\x81b"), "", true},
+		{"utf-8", "replace", []byte("José \xff"), "José �", false},
+		{"utf-8", "skip", []byte("José \xff"), "José ", false},
+		{"utf-16", "replace", []byte("\xfe\xff\x00J\x00\xe9\xd8\x3d\xde\x00"), "Jé😀", false},
+		{"utf-16", "replace", []byte("\xff\xfeJ\x00\xe9\x00\x3d\xd8\x00\xde"), "Jé😀", false},
+		{"utf-16", "replace", []byte("\x00J\x00\xe9"), "Jé", false},
+		{"utf-16le", "replace", []byte("J\x00\n\x00"), "J\n", false},
+		{"utf-16be", "replace", []byte("\xdc\x00\x00J\x00"), "�J�", false},
+		{"utf-16be", "fail", []byte("\xd8\x3d\x00J"), "", true},
+	}
+
+	for _, ts := range tests {
+		r, err := newDecodingReader(iotest.OneByteReader(bytes.NewReader(ts.input)), ts.encoding, ts.invalid)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := ioutil.ReadAll(r)
+		if ts.fails && err == nil {
+			t.Errorf("decoding %q from %v should have failed", ts.input, ts.encoding)
+		}
+		if !ts.fails && string(b) != ts.expected {
+			t.Errorf("decoding %q from %v should have resulted in %q, but it was %q", ts.input, ts.encoding, ts.expected, b)
+		}
+	}
+}
+
+func TestDiffDifferentlyEncodedStreams(t *testing.T) {
+	utils := mockStreamUtils{
+		i:     strings.NewReader("Jos\xe9\nM\xfcller\nSmith\n"),
+		stdin: streamOptions{encoding: "latin-1", invalidBytes: "replace"},
+	}
+
+	stdout := make(chan result)
+	go diff(`echo -e "José\nMüller"`, &listDiffee{}, defaultTimeout(), defaultTimeout(), stdout, utils, false, nil)
+
+	lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{}.format)
+
+	if reflect.DeepEqual(lines, []string{"Smith"}) != true {
+		t.Errorf("result wasn't ['Smith'], it was %v", lines)
+	}
+}
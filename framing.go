@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// framer groups consecutive lines of a stream into records.
+type framer interface {
+	// push adds a line, returning a record if the line completed one.
+	push(line string) (string, bool, error)
+	// flush returns the last record, if any, once the stream ends.
+	flush() (string, bool)
+}
+
+// lineFramer makes every line a record.
+type lineFramer struct{}
+
+func (lineFramer) push(line string) (string, bool, error) {
+	return line, true, nil
+}
+
+func (lineFramer) flush() (string, bool) {
+	return "", false
+}
+
+// recordLines are the lines of a record being framed. Records longer than max
+// bytes are handled like lines are by limitSplit, with the oversized policy.
+type recordLines struct {
+	max      int
+	policy   string
+	lines    []string
+	size     int
+	oversize bool
+}
+
+func (r *recordLines) add(line string) error {
+	if r.oversize {
+		return nil
+	}
+	size := r.size + len(line)
+	if len(r.lines) > 0 {
+		size++ // the newline joining it
+	}
+	if size <= r.max {
+		r.lines = append(r.lines, line)
+		r.size = size
+		return nil
+	}
+
+	r.oversize = true
+	switch r.policy {
+	case "truncate":
+		log.Printf("truncated a record longer than %v bytes", r.max)
+		if room := r.max - (size - len(line)); room >= 0 {
+			r.lines = append(r.lines, line[:room])
+		}
+		return nil
+	case "skip":
+		log.Printf("skipped a record longer than %v bytes", r.max)
+		r.lines = nil
+		return nil
+	default:
+		return fmt.Errorf("found a record longer than %v bytes; see --max-record-size", r.max)
+	}
+}
+
+func (r *recordLines) flush() (string, bool) {
+	record, ok := strings.Join(r.lines, "\n"), len(r.lines) > 0
+	r.lines, r.size, r.oversize = nil, 0, false
+	return record, ok
+}
+
+// paragraphFramer makes records out of lines separated by blank lines.
+type paragraphFramer struct {
+	recordLines
+}
+
+func (f *paragraphFramer) push(line string) (string, bool, error) {
+	if strings.TrimSpace(line) == "" {
+		record, ok := f.flush()
+		return record, ok, nil
+	}
+	return "", false, f.add(line)
+}
+
+// startFramer starts a new record on every line that matches start.
+type startFramer struct {
+	recordLines
+	start *regexp.Regexp
+}
+
+func (f *startFramer) push(line string) (string, bool, error) {
+	var record string
+	var ok bool
+	if f.start.MatchString(line) {
+		record, ok = f.flush()
+	}
+	return record, ok, f.add(line)
+}
+
+// countFramer makes a record out of every n lines.
+type countFramer struct {
+	recordLines
+	n     int
+	count int
+}
+
+func (f *countFramer) push(line string) (string, bool, error) {
+	err := f.add(line)
+	if f.count++; f.count < f.n {
+		return "", false, err
+	}
+	record, ok := f.flush()
+	return record, ok, err
+}
+
+func (f *countFramer) flush() (string, bool) {
+	f.count = 0
+	return f.recordLines.flush()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFramers(t *testing.T) {
+	tests := []struct {
+		input    string
+		so       streamOptions
+		expected []string
+	}{
+		{
+			input:    "1\n2\n3",
+			so:       streamOptions{},
+			expected: []string{"1", "2", "3"},
+		},
+		{
+			input:    "\n1\n2\n\n  \n3\n\n4\n5\n",
+			so:       streamOptions{paragraph: true},
+			expected: []string{"1\n2", "3", "4\n5"},
+		},
+		{
+			input:    "preamble\nERROR a\n  at x\n  at y\nERROR b\nERROR c\n  at z",
+			so:       streamOptions{recordStart: regexp.MustCompile(`^ERROR`)},
+			expected: []string{"preamble", "ERROR a\n  at x\n  at y", "ERROR b", "ERROR c\n  at z"},
+		},
+		{
+			input:    "1\n2\n3\n4\n5",
+			so:       streamOptions{recordLines: 2},
+			expected: []string{"1\n2", "3\n4", "5"},
+		},
+		{
+			input:    "1\n2\n\n333\n444\n555\n\n6",
+			so:       streamOptions{paragraph: true, maxRecordSize: 5, oversized: "truncate"},
+			expected: []string{"1\n2", "333\n4", "6"},
+		},
+		{
+			input:    "1\n2\n\n333\n444\n555\n\n6",
+			so:       streamOptions{paragraph: true, maxRecordSize: 5, oversized: "skip"},
+			expected: []string{"1\n2", "6"},
+		},
+		{
+			input:    "1\n22\n333\n4\n5\n6",
+			so:       streamOptions{recordLines: 2, maxRecordSize: 4, oversized: "skip"},
+			expected: []string{"1\n22", "5\n6"},
+		},
+	}
+
+	for _, ts := range tests {
+		to := make(chan string, 10)
+		scanToChannel(strings.NewReader(ts.input), to, make(chan struct{}), ts.so)
+
+		records := []string{}
+		for record := range to {
+			records = append(records, record)
+		}
+
+		if !reflect.DeepEqual(records, ts.expected) {
+			t.Errorf("framing %q should have resulted in %q, but it was %q", ts.input, ts.expected, records)
+		}
+	}
+}
+
+func TestReadCmdWithParagraphs(t *testing.T) {
+	o := make(chan string, 2)
+
+	readCmd(`echo -e "1\n2\n\n3\n4"`, o, make(chan struct{}), streamOptions{paragraph: true})
+
+	records := readAndSortBlocking(o, 1*time.Second)
+
+	if reflect.DeepEqual(records, []string{"1\n2", "3\n4"}) != true {
+		t.Errorf("result wasn't ['1\\n2', '3\\n4'], it was %q", records)
+	}
+}
+
+func TestFramerFailsOnOversizedRecords(t *testing.T) {
+	f := streamOptions{recordStart: regexp.MustCompile(`^E`), maxRecordSize: 5, oversized: "fail"}.newFramer()
+
+	for _, line := range []string{"E 1", "a"} {
+		if _, _, err := f.push(line); err != nil {
+			t.Fatalf("%q shouldn't have failed: %v", line, err)
+		}
+	}
+	if _, _, err := f.push("b"); err == nil {
+		t.Error("a record longer than 5 bytes should have failed")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+// bkTree is a diffee that considers lines equal if their Levenshtein distance
+// is up to maxDistance. Lines are kept in a BK-tree, so queries only compare
+// against the lines whose distance to each visited node makes them
+// candidates, rather than against every line.
+type bkTree struct {
+	maxDistance int
+	root        *bkNode
+}
+
+type bkNode struct {
+	line     string
+	children map[int]*bkNode
+}
+
+func newBKTree(maxDistance int) *bkTree {
+	return &bkTree{maxDistance: maxDistance}
+}
+
+func (t *bkTree) add(s string) {
+	if t.root == nil {
+		t.root = &bkNode{line: s}
+		return
+	}
+
+	n := t.root
+	for {
+		d := levenshtein(s, n.line)
+		if d == 0 {
+			return
+		}
+		child, ok := n.children[d]
+		if !ok {
+			if n.children == nil {
+				n.children = make(map[int]*bkNode)
+			}
+			n.children[d] = &bkNode{line: s}
+			return
+		}
+		n = child
+	}
+}
+
+func (t *bkTree) contains(s string) bool {
+	_, ok := t.search(s, true)
+	return ok
+}
+
+// match returns the closest line to s, if any is close enough.
+func (t *bkTree) match(s string) (string, bool) {
+	return t.search(s, false)
+}
+
+func (t *bkTree) search(s string, first bool) (string, bool) {
+	if t.root == nil {
+		return "", false
+	}
+
+	best, bestDistance := "", t.maxDistance+1
+	pending := []*bkNode{t.root}
+	for len(pending) > 0 {
+		n := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		d := levenshtein(s, n.line)
+		if d < bestDistance {
+			best, bestDistance = n.line, d
+			if first || d == 0 {
+				break
+			}
+		}
+		for cd, child := range n.children {
+			if cd >= d-t.maxDistance && cd <= d+t.maxDistance {
+				pending = append(pending, child)
+			}
+		}
+	}
+
+	return best, bestDistance <= t.maxDistance
+}
+
+// levenshtein returns the number of single rune insertions, deletions or
+// substitutions needed to turn a into b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(rb)]
+}
+
+// appendMatch sets the match of each result to its closest line in t,
+// according to the key of the line.
+func appendMatch(stdout chan result, t *bkTree, key keyer) chan result {
+	results := make(chan result)
+	go func() {
+		for r := range results {
+			r.match, _ = t.match(key(r.line))
+			stdout <- r
+		}
+		close(stdout)
+	}()
+
+	return results
+}
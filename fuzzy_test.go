@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"kitten", "sitting", 3},
+		{"Zürich", "Zurich", 1},
+		{"flaw", "lawn", 2},
+	}
+
+	for _, ts := range tests {
+		if d := levenshtein(ts.a, ts.b); d != ts.expected {
+			t.Errorf("distance between %q and %q wasn't %v, it was %v", ts.a, ts.b, ts.expected, d)
+		}
+	}
+}
+
+func TestBKTree(t *testing.T) {
+	tree := newBKTree(2)
+	for _, s := range []string{"Buenos Aires", "Barcelona", "Berlin", "Bern", "Zurich", "Berlin"} {
+		tree.add(s)
+	}
+
+	tests := []struct {
+		line  string
+		match string
+		ok    bool
+	}{
+		{"Buenos Aries", "Buenos Aires", true},
+		{"Zürich", "Zurich", true},
+		{"Bern", "Bern", true},
+		{"Berln", "Berlin", true},
+		{"Bremen", "", false},
+		{"Madrid", "", false},
+	}
+	for _, ts := range tests {
+		match, ok := tree.match(ts.line)
+		if match != ts.match || ok != ts.ok {
+			t.Errorf("match of %q wasn't %q, %v, it was %q, %v", ts.line, ts.match, ts.ok, match, ok)
+		}
+		if tree.contains(ts.line) != ts.ok {
+			t.Errorf("contains(%q) wasn't %v", ts.line, ts.ok)
+		}
+	}
+}
+
+func TestFuzzyIntersectionWithMatch(t *testing.T) {
+	stdout := make(chan result)
+	reader := strings.NewReader("Cordoba\nRosario\nMendosa\n")
+	tree := newBKTree(1)
+
+	go diff(`echo -e "Córdoba\nMendoza\nSalta"`, tree, defaultTimeout(), defaultTimeout(), appendMatch(stdout, tree, wholeLine), mockUtils{reader}, true, nil)
+
+	lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{separator: "\t", matches: true}.format)
+
+	expected := []string{"Cordoba\tCórdoba", "Mendosa\tMendoza"}
+	if reflect.DeepEqual(lines, expected) != true {
+		t.Errorf("result wasn't %q, it was %q", expected, lines)
+	}
+}
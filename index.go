@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// An index file is a sorted, deduplicated set of lines that can be searched
+// without loading it into memory. Its layout is:
+//
+//	magic   [8]byte
+//	count   uint64
+//	offsets [count+1]uint64 (relative to the start of data)
+//	data    the concatenated lines, without separators
+//
+// All integers are big endian.
+var indexMagic = []byte("SDINDEX1")
+
+const indexHeaderSize = 16
+
+var errNotAnIndex = errors.New("not an sd index file")
+
+// indexRunSize is about how many bytes of memory the lines sorted at a time
+// take while building an index, counting the 16 bytes of each string header.
+const indexRunSize = 32 << 20
+
+// buildIndex reads lines from r and writes them as an index file to path.
+// Lines are sorted in runs of up to runSize bytes that are written to
+// temporary files, and the runs are then merged, so the lines don't need to
+// fit in memory. The file is replaced atomically.
+func buildIndex(r io.Reader, path string, runSize int) error {
+	dir, err := ioutil.TempDir("", "sd")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	var runs []*os.File
+	defer func() {
+		for _, f := range runs {
+			f.Close()
+		}
+	}()
+	var lines []string
+	size := 0
+	writeRun := func() error {
+		sort.Strings(lines)
+		f, err := writeTempLines(dir, fmt.Sprintf("run.%v", len(runs)), uniqStrings(lines))
+		if err != nil {
+			return err
+		}
+		runs = append(runs, f)
+		lines, size = nil, 0
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		size += len(scanner.Text()) + 16
+		if size >= runSize {
+			if err := writeRun(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(lines) > 0 {
+		if err := writeRun(); err != nil {
+			return err
+		}
+	}
+
+	offsets, err := os.Create(filepath.Join(dir, "offsets"))
+	if err != nil {
+		return err
+	}
+	defer offsets.Close()
+	data, err := os.Create(filepath.Join(dir, "data"))
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	count, err := mergeRuns(runs, offsets, data)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(path, func(w io.Writer) error {
+		return writeIndex(w, count, offsets, data)
+	})
+}
+
+// writeTempLines writes lines as records to a new file in dir, which is
+// returned ready to be read.
+func writeTempLines(dir, name string, lines []string) (*os.File, error) {
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, s := range lines {
+		writeRecord(w, "", s, 0)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// run is the next line of a sorted run that's being merged.
+type run struct {
+	r    *bufio.Reader
+	line string
+}
+
+type runHeap []*run
+
+func (h runHeap) Len() int            { return len(h) }
+func (h runHeap) Less(i, j int) bool  { return h[i].line < h[j].line }
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*run)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// mergeRuns merges the sorted runs into the offsets and the data of an index,
+// without duplicates, and returns how many lines there are. Both files are
+// left ready to be read.
+func mergeRuns(runs []*os.File, offsets, data *os.File) (int, error) {
+	h := &runHeap{}
+	for _, f := range runs {
+		r := bufio.NewReader(f)
+		_, line, _, err := readRecord(r)
+		if err == io.EOF {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		*h = append(*h, &run{r, line})
+	}
+	heap.Init(h)
+
+	ow, dw := bufio.NewWriter(offsets), bufio.NewWriter(data)
+	count := 0
+	var offset uint64
+	var previous string
+	for h.Len() > 0 {
+		next := (*h)[0]
+		if count == 0 || next.line != previous {
+			binary.Write(ow, binary.BigEndian, offset)
+			dw.WriteString(next.line)
+			offset += uint64(len(next.line))
+			previous = next.line
+			count++
+		}
+
+		_, line, _, err := readRecord(next.r)
+		switch {
+		case err == io.EOF:
+			heap.Pop(h)
+		case err != nil:
+			return 0, err
+		default:
+			next.line = line
+			heap.Fix(h, 0)
+		}
+	}
+	binary.Write(ow, binary.BigEndian, offset)
+
+	for _, f := range []struct {
+		w *bufio.Writer
+		f *os.File
+	}{{ow, offsets}, {dw, data}} {
+		if err := f.w.Flush(); err != nil {
+			return 0, err
+		}
+		if _, err := f.f.Seek(0, 0); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+// writeIndex writes an index of count lines, whose offsets and data are
+// read from the specified readers.
+func writeIndex(w io.Writer, count int, offsets, data io.Reader) error {
+	bw := bufio.NewWriter(w)
+	bw.Write(indexMagic)
+	binary.Write(bw, binary.BigEndian, uint64(count))
+	if _, err := io.Copy(bw, offsets); err != nil {
+		return err
+	}
+	if _, err := io.Copy(bw, data); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+func uniqStrings(sorted []string) []string {
+	if len(sorted) == 0 {
+		return sorted
+	}
+	j := 0
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i] != sorted[j] {
+			j++
+			sorted[j] = sorted[i]
+		}
+	}
+	return sorted[:j+1]
+}
+
+func mustRunIndex(args []string) {
+	if len(args) != 1 {
+		usage()
+		os.Exit(1)
+	}
+
+	if err := buildIndex(os.Stdin, args[0], indexRunSize); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// indexDiffee is a read-only diffee backed by an index file. Lookups binary
+// search the file, so only the pages that are touched are ever read.
+type indexDiffee struct {
+	f         *os.File
+	count     int
+	dataStart int64
+}
+
+func openIndex(path string) (*indexDiffee, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, indexHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		f.Close()
+		return nil, errNotAnIndex
+	}
+	if !bytes.Equal(header[:8], indexMagic) {
+		f.Close()
+		return nil, errNotAnIndex
+	}
+
+	count := binary.BigEndian.Uint64(header[8:])
+	return &indexDiffee{
+		f:         f,
+		count:     int(count),
+		dataStart: indexHeaderSize + int64(count+1)*8,
+	}, nil
+}
+
+func mustOpenIndex(path string) *indexDiffee {
+	d, err := openIndex(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return d
+}
+
+func (d *indexDiffee) add(s string) {
+	log.Fatal("cannot add lines to an index")
+}
+
+func (d *indexDiffee) contains(s string) bool {
+	i := sort.Search(d.count, func(i int) bool {
+		return d.line(i) >= s
+	})
+	return i < d.count && d.line(i) == s
+}
+
+func (d *indexDiffee) line(i int) string {
+	offsets := make([]byte, 16)
+	if _, err := d.f.ReadAt(offsets, indexHeaderSize+int64(i)*8); err != nil {
+		log.Fatal(err)
+	}
+	from := binary.BigEndian.Uint64(offsets[:8])
+	to := binary.BigEndian.Uint64(offsets[8:])
+
+	b := make([]byte, to-from)
+	if _, err := d.f.ReadAt(b, d.dataStart+int64(from)); err != nil {
+		log.Fatal(err)
+	}
+
+	return string(b)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIndexContains(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.idx")
+	if err := buildIndex(strings.NewReader("c\na\nb\na\n\nlonger line\n"), path, indexRunSize); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := openIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d.count != 5 {
+		t.Errorf("index should have 5 unique lines, it had %v", d.count)
+	}
+
+	tests := []struct {
+		line     string
+		expected bool
+	}{
+		{"a", true},
+		{"b", true},
+		{"c", true},
+		{"", true},
+		{"longer line", true},
+		{"d", false},
+		{"longer", false},
+		{"0", false},
+	}
+
+	for _, ts := range tests {
+		if d.contains(ts.line) != ts.expected {
+			t.Errorf("contains(%q) should have been %v", ts.line, ts.expected)
+		}
+	}
+}
+
+func TestBuildIndexInRuns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for input, expected := range map[string][]string{
+		"9\n3\n7\n3\n1\n9\n\n5\n": {"", "1", "3", "5", "7", "9"},
+		"":                        nil,
+	} {
+		path := filepath.Join(dir, "test.idx")
+		if err := buildIndex(strings.NewReader(input), path, 3); err != nil {
+			t.Fatal(err)
+		}
+		d := mustOpenIndex(path)
+
+		var lines []string
+		for i := 0; i < d.count; i++ {
+			lines = append(lines, d.line(i))
+		}
+		if !reflect.DeepEqual(lines, expected) {
+			t.Errorf("index of %q wasn't %q, it was %q", input, expected, lines)
+		}
+		d.f.Close()
+	}
+}
+
+func TestOpenIndexRejectsOtherFiles(t *testing.T) {
+	f, err := ioutil.TempFile("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("1\n2\n3\n4\n5\n6\n7\n8\n9\n")
+	f.Close()
+
+	if _, err := openIndex(f.Name()); err != errNotAnIndex {
+		t.Errorf("should have failed with %v, but failed with %v", errNotAnIndex, err)
+	}
+}
+
+func TestDiffWithIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.idx")
+	if err := buildIndex(strings.NewReader("2\n4\n"), path, indexRunSize); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := make(chan result)
+	reader := cmdToReader(`echo -e "1\n2\n3\n4\n5"`)
+
+	go diff("", mustOpenIndex(path), defaultTimeout(), defaultTimeout(), stdout, mockUtils{reader}, false, nil)
+
+	lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{}.format)
+
+	if reflect.DeepEqual(lines, []string{"1", "3", "5"}) != true {
+		t.Errorf("result wasn't ['1', '3', '5'], it was %v", lines)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+// joinDiffee keeps the command's lines by key, so the lines that match each
+// STDIN line can be joined to it. If outer is set, it contains every line,
+// so that lines without matches are output too.
+type joinDiffee struct {
+	stdinKey keyer
+	cmdKey   keyer
+	outer    bool
+	lines    map[string][]string
+}
+
+func newJoinDiffee(stdinKey, cmdKey keyer, outer bool) *joinDiffee {
+	return &joinDiffee{stdinKey: stdinKey, cmdKey: cmdKey, outer: outer, lines: make(map[string][]string)}
+}
+
+func (d *joinDiffee) add(s string) {
+	k := d.cmdKey(s)
+	d.lines[k] = append(d.lines[k], s)
+}
+
+func (d *joinDiffee) contains(s string) bool {
+	return d.outer || len(d.matches(s)) > 0
+}
+
+func (d *joinDiffee) matches(s string) []string {
+	return d.lines[d.stdinKey(s)]
+}
+
+// joinLines outputs each result once per matching line in d, or once on its
+// own as only in STDIN if there are none. Only the last of them is done, so
+// the line isn't checkpointed until all of them are written.
+func joinLines(stdout chan result, d *joinDiffee) chan result {
+	results := make(chan result)
+	go func() {
+		for r := range results {
+			matches := d.matches(r.line)
+			if len(matches) == 0 {
+				r.side = "left"
+				stdout <- r
+			}
+			done := r.done
+			for i, m := range matches {
+				r.match = m
+				r.done = nil
+				if i == len(matches)-1 {
+					r.done = done
+				}
+				stdout <- r
+			}
+		}
+		close(stdout)
+	}()
+
+	return results
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJoin(t *testing.T) {
+	stdinKey, cmdKey := fieldsKey([]int{1}, "\t"), fieldsKey([]int{2}, "\t")
+	tests := []struct {
+		kind     string
+		expected []string
+	}{
+		{"inner", []string{"1\tjohn\tadmin\t1", "1\tjohn\tuser\t1", "3\tjim\tuser\t3"}},
+		{"left", []string{"1\tjohn\tadmin\t1", "1\tjohn\tuser\t1", "2\tjane\t", "3\tjim\tuser\t3"}},
+		{"anti", []string{"2\tjane\t"}},
+	}
+
+	for _, ts := range tests {
+		stdout := make(chan result)
+		reader := strings.NewReader("1\tjohn\n2\tjane\n3\tjim\n")
+		d := newJoinDiffee(stdinKey, cmdKey, ts.kind == "left")
+		results := joinLines(stdout, d)
+
+		go diff(`echo -e "admin\t1\nuser\t1\nuser\t3\nuser\t4"`, d, defaultTimeout(), defaultTimeout(), results, mockUtils{reader}, ts.kind != "anti", nil)
+
+		lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{separator: "\t", matches: true}.format)
+
+		if reflect.DeepEqual(lines, ts.expected) != true {
+			t.Errorf("%v join wasn't %q, it was %q", ts.kind, ts.expected, lines)
+		}
+	}
+}
+
+func TestJoinIsDoneOnceEveryMatchIsOutput(t *testing.T) {
+	d := newJoinDiffee(fieldsKey([]int{1}, "\t"), fieldsKey([]int{1}, "\t"), false)
+	d.add("1\tadmin")
+	d.add("1\tuser")
+	stdout := make(chan result, 2)
+	results := joinLines(stdout, d)
+
+	done := 0
+	results <- result{line: "1\tjohn", done: func() { done++ }}
+	close(results)
+
+	var dones []bool
+	for r := range stdout {
+		dones = append(dones, r.done != nil)
+		if r.done != nil {
+			r.done()
+		}
+	}
+	if !reflect.DeepEqual(dones, []bool{false, true}) || done != 1 {
+		t.Errorf("only the last match wasn't done, they were %v", dones)
+	}
+}
@@ -1,29 +1,32 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
-	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 type iDiffUtils interface {
 	scanStdinToChannel(to chan string, cancel chan struct{})
+	scanCmdToChannel(cmdString string, to chan string, cancel chan struct{})
+}
+type diffUtils struct {
+	stdin streamOptions
+	cmd   streamOptions
 }
-type diffUtils struct{}
 
-func scanToChannel(from io.Reader, to chan string, cancel chan struct{}) {
-	scanner := bufio.NewScanner(from)
+func scanToChannel(from io.Reader, to chan string, cancel chan struct{}, so streamOptions) {
 	intermediate := make(chan string)
 
 	go func() {
-		for scanner.Scan() {
-			intermediate <- scanner.Text()
-		}
+		so.scan(from, intermediate)
 		close(intermediate)
 	}()
 
@@ -43,10 +46,14 @@ loop:
 }
 
 func (d diffUtils) scanStdinToChannel(i chan string, cancel chan struct{}) {
-	scanToChannel(os.Stdin, i, cancel)
+	scanToChannel(os.Stdin, i, cancel, d.stdin)
+}
+
+func (d diffUtils) scanCmdToChannel(cmdString string, o chan string, cancel chan struct{}) {
+	readCmd(cmdString, o, cancel, d.cmd)
 }
 
-func readCmd(cmdString string, o chan string, cancel chan struct{}) {
+func readCmd(cmdString string, o chan string, cancel chan struct{}, so streamOptions) {
 	var stderr bytes.Buffer
 	cmd := exec.Command("/bin/bash", "-c", cmdString)
 	cmd.Stderr = &stderr
@@ -61,66 +68,67 @@ func readCmd(cmdString string, o chan string, cancel chan struct{}) {
 	}
 
 	go func() {
-		scanToChannel(stdout, o, cancel)
+		scanToChannel(stdout, o, cancel, so)
 		cmd.Process.Kill()
 	}()
 }
 
-func diffLine(v string, stdout chan string, diffee *[]string, start chan struct{}, intersection bool, wg *sync.WaitGroup) {
+func diffLine(v string, n int, stdout chan result, d diffee, start chan struct{}, intersection bool, cp *checkpoint, wg *sync.WaitGroup) {
 	<-start // wait until diffee finishes loading
 
-	if intersection {
-		for _, w := range *diffee {
-			if v == w {
-				stdout <- v
-				break
-			}
+	contains := d.contains(v)
+	if contains {
+		atomic.AddInt64(&stats.matched, 1)
+	}
+	if contains == intersection {
+		r := result{line: v, side: side(intersection), n: n + 1}
+		if cp != nil {
+			r.done = func() { cp.done(n) }
 		}
+		stdout <- r
 	} else {
-		found := false
-		for _, w := range *diffee {
-			if v == w {
-				found = true
-				break
-			}
-		}
-		if !found {
-			stdout <- v
-		}
+		cp.done(n)
 	}
 
+	atomic.AddInt64(&stats.pending, -1)
 	wg.Done()
 }
 
-func printLn(stdout chan string, done chan struct{}) {
-	for s := range stdout {
-		fmt.Println(s)
+// side is the side of the lines output in intersection mode or otherwise.
+func side(intersection bool) string {
+	if intersection {
+		return "common"
 	}
-	close(done)
+	return "left"
 }
 
 func processStdin(
 	stdinCh chan string,
-	diffee *[]string,
+	d diffee,
 	stdinTimeout timeout,
 	cancelStdin chan struct{},
 	start chan struct{},
-	stdout chan string,
+	stdout chan result,
 	intersection bool,
+	cp *checkpoint,
 	wg *sync.WaitGroup) {
 
 	stdinTimeout.Start()
 	var innerWg sync.WaitGroup
 
 loop:
-	for {
+	for n := 0; ; n++ {
 		select {
 		case s, ok := <-stdinCh:
 			if !ok {
 				break loop
 			}
-			innerWg.Add(1)
-			go diffLine(s, stdout, diffee, start, intersection, &innerWg)
+			atomic.AddInt64(&stats.stdin.lines, 1)
+			if !cp.skipped(n) {
+				atomic.AddInt64(&stats.pending, 1)
+				innerWg.Add(1)
+				go diffLine(s, n, stdout, d, start, intersection, cp, &innerWg)
+			}
 			stdinTimeout.Reset()
 		case <-*stdinTimeout.c:
 			close(cancelStdin)
@@ -128,21 +136,24 @@ loop:
 		}
 	}
 
+	stdinTimeout.deadline.clear()
 	innerWg.Wait()
 	wg.Done()
 }
 
-func processCmd(cmdCh chan string, diffee *[]string, cmdTimeout timeout, cancelCmd chan struct{}, start chan struct{}, wg *sync.WaitGroup) {
+func processCmd(cmdCh chan string, d diffee, cmdTimeout timeout, cancelCmd chan struct{}, start chan struct{}, wg *sync.WaitGroup) {
 	cmdTimeout.Start()
 	for {
 		select {
 		case s, ok := <-cmdCh:
 			if !ok {
+				cmdTimeout.deadline.clear()
 				close(start)
 				wg.Done()
 				return
 			}
-			*diffee = append(*diffee, s)
+			atomic.AddInt64(&stats.cmd.lines, 1)
+			d.add(s)
 			cmdTimeout.Reset()
 		case <-*cmdTimeout.c:
 			close(cancelCmd)
@@ -150,9 +161,10 @@ func processCmd(cmdCh chan string, diffee *[]string, cmdTimeout timeout, cancelC
 	}
 }
 
-func diff(cmd string, stdinTimeout timeout, cmdTimeout timeout, stdout chan string, utils iDiffUtils, intersection bool) {
-	var diffee []string
-
+// diff outputs the lines from STDIN that are not in d (or only those that are,
+// in intersection mode) once COMMAND has been loaded into d. If cmd is empty,
+// d is used as it is. cp may be nil.
+func diff(cmd string, d diffee, stdinTimeout timeout, cmdTimeout timeout, stdout chan result, utils iDiffUtils, intersection bool, cp *checkpoint) {
 	stdinCh := make(chan string)
 	cmdCh := make(chan string)
 	start := make(chan struct{})
@@ -160,18 +172,35 @@ func diff(cmd string, stdinTimeout timeout, cmdTimeout timeout, stdout chan stri
 	cancelStdin := make(chan struct{})
 
 	go utils.scanStdinToChannel(stdinCh, cancelStdin)
-	go readCmd(cmd, cmdCh, cancelCmd)
+	if cmd != "" {
+		go utils.scanCmdToChannel(cmd, cmdCh, cancelCmd)
+	} else {
+		close(cmdCh)
+	}
 
 	var wg sync.WaitGroup
 	wg.Add(2)
 
-	go processStdin(stdinCh, &diffee, stdinTimeout, cancelStdin, start, stdout, intersection, &wg)
-	go processCmd(cmdCh, &diffee, cmdTimeout, cancelCmd, start, &wg)
+	go processStdin(stdinCh, d, stdinTimeout, cancelStdin, start, stdout, intersection, cp, &wg)
+	go processCmd(cmdCh, d, cmdTimeout, cancelCmd, start, &wg)
 
 	wg.Wait()
 	close(stdout)
 }
 
+// exitOnSignal calls every function in onExit before exiting on SIGINT or
+// SIGTERM, which is how follow mode normally ends.
+func exitOnSignal(onExit ...func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+
+	for _, f := range onExit {
+		f()
+	}
+	os.Exit(0)
+}
+
 var args []string
 
 func main() {
@@ -181,14 +210,144 @@ func main() {
 	}
 	args := os.Args[1:]
 
+	switch args[0] {
+	case "index":
+		mustRunIndex(args[1:])
+		return
+	case "bloom":
+		mustRunBloom(args[1:])
+		return
+	case "serve":
+		mustRunServe(args[1:])
+		return
+	}
+
 	options := mustResolveOptions(args)
 	stdinTimeout, cmdTimeout := resolveTimeouts(options)
+	stdinTimeout.resets, cmdTimeout.resets = &stats.stdin.timeoutResets, &stats.cmd.timeoutResets
+
+	stdinStream, cmdStream := resolveStreamOptions(options)
+	utils := diffUtils{stdin: stdinStream, cmd: cmdStream}
+
+	var d diffee = &listDiffee{}
+	var tree *bkTree
+	var joiner *joinDiffee
+	var changes *changesDiffee
 	cmd := os.Args[len(os.Args)-1]
+	switch {
+	case options.index != "":
+		d = mustOpenIndex(options.index)
+		cmd = ""
+	case options.bloom != "":
+		d = mustOpenBloomDiffee(options.bloom)
+		cmd = ""
+	case options.bloomRate > 0:
+		d = newBloomDiffee(options.bloomRate)
+	case options.tolerance > 0:
+		d = newToleranceDiffee(options.tolerance)
+	case options.fuzzy > 0:
+		tree = newBKTree(options.fuzzy)
+		d = tree
+	case options.match == "glob" || options.match == "regex":
+		d = newPatternDiffee(options.match == "glob")
+	case options.match == "prefix" || options.match == "substring":
+		d = newTrieDiffee(options.match == "substring")
+	case options.join != "":
+		joiner = newJoinDiffee(stdinStream.key(), cmdStream.key(), options.join == "left")
+		d = joiner
+	case options.changes:
+		changes = newChangesDiffee(stdinStream, cmdStream)
+		d = changes
+	}
 
-	stdout := make(chan string)
-	done := make(chan struct{})
+	keys := d // where the keys saved in --state are loaded
+	if joiner == nil && changes == nil && (stdinStream.keyed() || cmdStream.keyed()) {
+		d = keyedDiffee{d, stdinStream.key(), cmdStream.key()}
+	}
+	d = countingDiffee{d}
 
-	go printLn(stdout, done)
-	diff(cmd, stdinTimeout, cmdTimeout, stdout, diffUtils{}, options.intersection)
+	stdout := make(chan result)
+	stopOutput, done := make(chan struct{}), make(chan struct{})
+
+	sinks, closeSinks := mustOpenSinks(resolveSinks(options), options.gzipOutput, int64(options.rotateSize), time.Duration(options.rotateInterval)*time.Second)
+	colorSinks(sinks, options.color)
+	// the output is stopped before the sinks are closed and the state saved,
+	// so that the lines written so far are done
+	onExit := []func(){func() {
+		select {
+		case <-done:
+		default:
+			close(stopOutput)
+			<-done
+		}
+	}, closeSinks}
+	go printLn(sinks, stdout, stopOutput, done, resolveOutputDelimiter(options), resolveFormatter(options, stdinStream, cmdStream).format)
+
+	results := stdout
+	if options.csv && options.template == "" {
+		results = prependCSVHeader(stdout, stdinStream.csv)
+	}
+	var st *state
+	if options.state != "" {
+		st = mustLoadState(options.state, time.Duration(options.stateRetention)*time.Second)
+		for _, line := range st.lines() {
+			countingDiffee{keys}.add(line)
+		}
+		if options.stateSource == "command" {
+			d = stateDiffee{d, st, cmdStream.key()}
+		} else {
+			d = refreshStateDiffee{d, st, stdinStream.key()}
+			results = recordToState(results, st, stdinStream.key())
+		}
+	}
+
+	if options.showMatch {
+		results = appendMatch(results, tree, stdinStream.key())
+	}
+	if joiner != nil {
+		results = joinLines(results, joiner)
+	}
+	if changes != nil {
+		results = reportChanges(results, changes, options.intersection)
+	}
+
+	var cp *checkpoint
+	if options.checkpoint != "" {
+		cp = mustOpenCheckpoint(options.checkpoint, options.resume, d)
+		d = checkpointDiffee{d, cp}
+		go cp.saveEvery(time.Duration(options.checkpointInterval) * time.Second)
+	}
+
+	if st != nil {
+		onExit = append(onExit, st.mustSave)
+	}
+	if cp != nil {
+		onExit = append(onExit, cp.mustSave)
+	}
+	if options.progress {
+		stdinTimeout.deadline, cmdTimeout.deadline = &deadline{}, &deadline{}
+		stop, stopped := make(chan struct{}), make(chan struct{})
+		go showProgress(os.Stderr, 200*time.Millisecond, stdinTimeout, cmdTimeout, stop, stopped)
+		onExit = append([]func(){func() {
+			close(stop)
+			<-stopped
+		}}, onExit...)
+	}
+
+	if options.metrics != "" {
+		mustServeMetrics(options.metrics)
+	}
+	go exitOnSignal(onExit...)
+
+	intersection := options.intersection || options.join == "inner" || options.join == "left" || options.changes
+	if options.spill > 0 {
+		spillDiff(cmd, options.spill, stdinTimeout, cmdTimeout, results, utils, intersection, stdinStream.key(), cmdStream.key())
+	} else {
+		diff(cmd, d, stdinTimeout, cmdTimeout, results, utils, intersection, cp)
+	}
 	<-done
+
+	for _, f := range onExit {
+		f()
+	}
 }
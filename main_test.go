@@ -1,8 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"io"
 	"log"
+	"os"
 	"os/exec"
 	"reflect"
 	"sort"
@@ -19,7 +21,7 @@ func TestScanToChannel(t *testing.T) {
 	to := make(chan string, 2)
 	cancel := make(chan struct{})
 
-	scanToChannel(from, to, cancel)
+	scanToChannel(from, to, cancel, streamOptions{})
 
 	lines := readAndSortBlocking(to, 1*time.Second)
 
@@ -33,7 +35,7 @@ func TestReadCmd(t *testing.T) {
 	o := make(chan string, 2)
 	cancel := make(chan struct{})
 
-	readCmd(cmdString, o, cancel)
+	readCmd(cmdString, o, cancel, streamOptions{})
 
 	lines := readAndSortBlocking(o, 1*time.Second)
 
@@ -44,26 +46,48 @@ func TestReadCmd(t *testing.T) {
 
 func TestDiff(t *testing.T) {
 	intersection := false
-	stdout := make(chan string)
+	stdout := make(chan result)
 	reader := cmdToReader(`echo -e "1\n2\n3\n4"`)
 
-	go diff(`echo -e "1\n2"`, defaultTimeout(), defaultTimeout(), stdout, mockUtils{reader}, intersection)
+	go diff(`echo -e "1\n2"`, &listDiffee{}, defaultTimeout(), defaultTimeout(), stdout, mockUtils{reader}, intersection, nil)
 
-	lines := readAndSortBlocking(stdout, 1*time.Second)
+	lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{}.format)
 
 	if reflect.DeepEqual(lines, []string{"3", "4"}) != true {
 		t.Errorf("result wasn't ['3', '4'], it was %v", lines)
 	}
 }
 
+func TestDiffByKeyFields(t *testing.T) {
+	stdout := make(chan result)
+	reader := strings.NewReader("1\tx\ta\n2\ty\tb\n3\tz\tc\n")
+	d := keyedDiffee{&listDiffee{}, fieldsKey([]int{1, 3}, "\t"), fieldsKey([]int{2, 1}, ",")}
+
+	go diff(`echo -e "a,1\nc,2"`, d, defaultTimeout(), defaultTimeout(), stdout, mockUtils{reader}, false, nil)
+
+	lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{}.format)
+
+	expected := []string{"2\ty\tb", "3\tz\tc"}
+	if reflect.DeepEqual(lines, expected) != true {
+		t.Errorf("result wasn't %q, it was %q", expected, lines)
+	}
+}
+
+func TestFieldsKeyWithMissingFields(t *testing.T) {
+	key := fieldsKey([]int{3, 1}, " ")
+	if k := key("a b"); k != "\x1fa" {
+		t.Errorf("key wasn't %q, it was %q", "\x1fa", k)
+	}
+}
+
 func TestIntersection(t *testing.T) {
 	intersection := true
-	stdout := make(chan string)
+	stdout := make(chan result)
 	reader := cmdToReader(`echo -e "1\n2\n3\n4"`)
 
-	go diff(`echo -e "1\n3"`, defaultTimeout(), defaultTimeout(), stdout, mockUtils{reader}, intersection)
+	go diff(`echo -e "1\n3"`, &listDiffee{}, defaultTimeout(), defaultTimeout(), stdout, mockUtils{reader}, intersection, nil)
 
-	lines := readAndSortBlocking(stdout, 1*time.Second)
+	lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{}.format)
 
 	if reflect.DeepEqual(lines, []string{"1", "3"}) != true {
 		t.Errorf("result wasn't ['1', '3'], it was %v", lines)
@@ -72,13 +96,13 @@ func TestIntersection(t *testing.T) {
 
 func TestDiffWhenInputTimesOut(t *testing.T) {
 	intersection := false
-	stdout := make(chan string)
+	stdout := make(chan result)
 
 	reader := cmdToReader(`echo -e "1\n3\n3\n3\n1\n2\n4" && sleep .101 && echo "5"`)
 
-	go diff(`echo -e "1\n2"`, defaultTimeout(), defaultTimeout(), stdout, mockUtils{reader}, intersection)
+	go diff(`echo -e "1\n2"`, &listDiffee{}, defaultTimeout(), defaultTimeout(), stdout, mockUtils{reader}, intersection, nil)
 
-	lines := readAndSortBlocking(stdout, 1*time.Second)
+	lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{}.format)
 
 	sort.Strings(lines) // order is not deterministic
 	if reflect.DeepEqual(lines, []string{"3", "3", "3", "4"}) != true {
@@ -88,12 +112,12 @@ func TestDiffWhenInputTimesOut(t *testing.T) {
 
 func TestDiffWhenOutputTimesOut(t *testing.T) {
 	intersection := false
-	stdout := make(chan string)
+	stdout := make(chan result)
 	reader := cmdToReader(`echo -e "1\n2\n3\n4\n5"`)
 
-	go diff(`echo -e "1\n2" && sleep 1 && echo -e "3\n4"`, defaultTimeout(), defaultTimeout(), stdout, mockUtils{reader}, intersection)
+	go diff(`echo -e "1\n2" && sleep 1 && echo -e "3\n4"`, &listDiffee{}, defaultTimeout(), defaultTimeout(), stdout, mockUtils{reader}, intersection, nil)
 
-	lines := readAndSortBlocking(stdout, 1*time.Second)
+	lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{}.format)
 
 	if reflect.DeepEqual(lines, []string{"3", "4", "5"}) != true {
 		t.Errorf("result wasn't ['3', '4', '5'], it was %v", lines)
@@ -102,11 +126,11 @@ func TestDiffWhenOutputTimesOut(t *testing.T) {
 
 func TestExpensiveTestCase(t *testing.T) {
 	intersection := false
-	stdout := make(chan string)
+	stdout := make(chan result)
 	reader := cmdToReader(`seq 10000`)
-	go diff(`seq 5001 10000`, defaultTimeout(), defaultTimeout(), stdout, mockUtils{reader}, intersection)
+	go diff(`seq 5001 10000`, &listDiffee{}, defaultTimeout(), defaultTimeout(), stdout, mockUtils{reader}, intersection, nil)
 
-	lines := readAndSortBlocking(stdout, 1*time.Second)
+	lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{}.format)
 
 	if len(lines) != 5000 {
 		t.Errorf("result didn't have 5000 lines, it had %v", len(lines))
@@ -115,13 +139,13 @@ func TestExpensiveTestCase(t *testing.T) {
 
 func TestDiffWhenDelaysAddUpToTimeoutSeparatelyButDoesntTimeout(t *testing.T) {
 	intersection := false
-	stdout := make(chan string)
+	stdout := make(chan result)
 	reader := cmdToReader(`echo -e "1\n2\n3\n4\n5\n6"`)
 
-	go diff(`echo "1" && sleep .1 && echo "2" && sleep .1 && echo "3" && sleep .1 && echo "4" && sleep .1 && echo "ten"`,
-		defaultTimeout(), timeout{firstTime: 200 * time.Millisecond, time: 200 * time.Millisecond}, stdout, mockUtils{reader}, intersection)
+	go diff(`echo "1" && sleep .1 && echo "2" && sleep .1 && echo "3" && sleep .1 && echo "4" && sleep .1 && echo "ten"`, &listDiffee{},
+		defaultTimeout(), timeout{firstTime: 200 * time.Millisecond, time: 200 * time.Millisecond}, stdout, mockUtils{reader}, intersection, nil)
 
-	lines := readAndSortBlocking(stdout, 1*time.Second)
+	lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{}.format)
 
 	if reflect.DeepEqual(lines, []string{"5", "6"}) != true {
 		t.Errorf("result wasn't ['5', '6'], it was %v", lines)
@@ -130,12 +154,12 @@ func TestDiffWhenDelaysAddUpToTimeoutSeparatelyButDoesntTimeout(t *testing.T) {
 
 func TestEmptyCommand(t *testing.T) {
 	intersection := false
-	stdout := make(chan string)
+	stdout := make(chan result)
 
 	reader := cmdToReader(`echo -e "1\n2\n3"`)
-	go diff(`echo ""`, defaultTimeout(), defaultTimeout(), stdout, mockUtils{reader}, intersection)
+	go diff(`echo ""`, &listDiffee{}, defaultTimeout(), defaultTimeout(), stdout, mockUtils{reader}, intersection, nil)
 
-	lines := readAndSortBlocking(stdout, 1*time.Second)
+	lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{}.format)
 
 	if reflect.DeepEqual(lines, []string{"1", "2", "3"}) != true {
 		t.Errorf("result wasn't ['1', '2', '3'], it was %v", lines)
@@ -144,11 +168,11 @@ func TestEmptyCommand(t *testing.T) {
 
 func TestEmptyStdin(t *testing.T) {
 	intersection := false
-	stdout := make(chan string)
+	stdout := make(chan result)
 
-	go diff(`echo "1\n2\n3"`, defaultTimeout(), defaultTimeout(), stdout, mockUtils{strings.NewReader(``)}, intersection)
+	go diff(`echo "1\n2\n3"`, &listDiffee{}, defaultTimeout(), defaultTimeout(), stdout, mockUtils{strings.NewReader(``)}, intersection, nil)
 
-	lines := readAndSortBlocking(stdout, 1*time.Second)
+	lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{}.format)
 
 	if reflect.DeepEqual(lines, []string{}) != true {
 		t.Errorf("result wasn't [], it was %v", lines)
@@ -197,6 +221,62 @@ type mockUtils struct {
 	i io.Reader
 }
 
+// readResultsAndSortBlocking is like readAndSortBlocking, but formats the
+// results with format.
+func readResultsAndSortBlocking(c chan result, timeout time.Duration, format func(result) string) []string {
+	lines := make(chan string)
+	go func() {
+		for r := range c {
+			lines <- format(r)
+			if r.done != nil {
+				r.done()
+			}
+		}
+		close(lines)
+	}()
+
+	return readAndSortBlocking(lines, timeout)
+}
+
 func (m mockUtils) scanStdinToChannel(i chan string, cancel chan struct{}) {
-	scanToChannel(m.i, i, cancel)
+	scanToChannel(m.i, i, cancel, streamOptions{})
+}
+
+func (m mockUtils) scanCmdToChannel(cmdString string, o chan string, cancel chan struct{}) {
+	readCmd(cmdString, o, cancel, streamOptions{})
+}
+
+type mockStreamUtils struct {
+	i     io.Reader
+	stdin streamOptions
+	cmd   streamOptions
+}
+
+func (m mockStreamUtils) scanStdinToChannel(i chan string, cancel chan struct{}) {
+	scanToChannel(m.i, i, cancel, m.stdin)
+}
+
+func (m mockStreamUtils) scanCmdToChannel(cmdString string, o chan string, cancel chan struct{}) {
+	readCmd(cmdString, o, cancel, m.cmd)
+}
+
+// runSd runs sd with args and stdin, through main, and returns its sorted
+// output lines.
+func runSd(t *testing.T, stdin string, args ...string) []string {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "SD_TEST_RUN_MAIN=1")
+	cmd.Stdin = strings.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("sd %v failed: %v: %v", args, err, stderr.String())
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	if len(out) == 0 {
+		lines = []string{}
+	}
+	sort.Strings(lines)
+	return lines
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+)
+
+// writeMetrics writes the counters in the Prometheus text format.
+func writeMetrics(w io.Writer, c *counters) {
+	metrics := []struct {
+		name, kind, help string
+		values           []string
+	}{
+		{"sd_lines_read_total", "counter", "Lines read from each stream.", []string{
+			fmt.Sprintf(`{stream="stdin"} %v`, atomic.LoadInt64(&c.stdin.lines)),
+			fmt.Sprintf(`{stream="command"} %v`, atomic.LoadInt64(&c.cmd.lines)),
+		}},
+		{"sd_timeout_resets_total", "counter", "Times each stream's timeout was reset by a line.", []string{
+			fmt.Sprintf(`{stream="stdin"} %v`, atomic.LoadInt64(&c.stdin.timeoutResets)),
+			fmt.Sprintf(`{stream="command"} %v`, atomic.LoadInt64(&c.cmd.timeoutResets)),
+		}},
+		{"sd_lines_matched_total", "counter", "STDIN lines found in the command.", []string{
+			fmt.Sprintf(` %v`, atomic.LoadInt64(&c.matched)),
+		}},
+		{"sd_lines_emitted_total", "counter", "Lines output.", []string{
+			fmt.Sprintf(` %v`, atomic.LoadInt64(&c.emitted)),
+		}},
+		{"sd_diffee_lines", "gauge", "Lines loaded into the diffee.", []string{
+			fmt.Sprintf(` %v`, atomic.LoadInt64(&c.diffeeSize)),
+		}},
+		{"sd_pending_lines", "gauge", "STDIN lines waiting to be diffed.", []string{
+			fmt.Sprintf(` %v`, atomic.LoadInt64(&c.pending)),
+		}},
+		{"sd_goroutines", "gauge", "Goroutines running, including one per pending line.", []string{
+			fmt.Sprintf(` %v`, runtime.NumGoroutine()),
+		}},
+	}
+
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", m.name, m.help, m.name, m.kind)
+		for _, v := range m.values {
+			fmt.Fprintf(w, "%v%v\n", m.name, v)
+		}
+	}
+}
+
+// mustServeMetrics serves the metrics in /metrics on addr, in the background.
+func mustServeMetrics(addr string) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	serveMetrics(l)
+}
+
+func serveMetrics(l net.Listener) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, &stats)
+	})
+	go func() {
+		log.Fatal(http.Serve(l, mux))
+	}()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	var b bytes.Buffer
+	c := counters{
+		stdin:      streamCounters{lines: 10, timeoutResets: 9},
+		cmd:        streamCounters{lines: 20, timeoutResets: 19},
+		diffeeSize: 20,
+		pending:    2,
+		matched:    5,
+		emitted:    3,
+	}
+	writeMetrics(&b, &c)
+
+	for _, expected := range []string{
+		"# TYPE sd_lines_read_total counter\n",
+		"sd_lines_read_total{stream=\"stdin\"} 10\n",
+		"sd_lines_read_total{stream=\"command\"} 20\n",
+		"sd_timeout_resets_total{stream=\"command\"} 19\n",
+		"sd_lines_matched_total 5\n",
+		"sd_lines_emitted_total 3\n",
+		"# TYPE sd_diffee_lines gauge\n",
+		"sd_diffee_lines 20\n",
+		"sd_pending_lines 2\n",
+		"sd_goroutines ",
+	} {
+		if !strings.Contains(b.String(), expected) {
+			t.Errorf("metrics don't contain %q:\n%v", expected, b.String())
+		}
+	}
+}
+
+func TestServeMetrics(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveMetrics(l)
+
+	resp, err := http.Get("http://" + l.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+
+	if !strings.Contains(string(b), "# TYPE sd_pending_lines gauge") {
+		t.Errorf("metrics weren't served, got %q", b)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var decimal = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$`)
+
+// parseNumber parses s as a decimal number, allowing surrounding spaces.
+func parseNumber(s string) (*big.Rat, bool) {
+	s = strings.TrimSpace(s)
+	if !decimal.MatchString(s) {
+		return nil, false
+	}
+	return new(big.Rat).SetString(s)
+}
+
+// numericKey returns a keyer of the keys of key with every number in canonical
+// form, so e.g. 007, 7 and 7.0 are equal. Parts of the key that aren't
+// numbers are left as is.
+func numericKey(key keyer) keyer {
+	return func(line string) string {
+		parts := strings.Split(key(line), "\x1f")
+		for i, p := range parts {
+			if r, ok := parseNumber(p); ok {
+				parts[i] = r.RatString()
+			}
+		}
+		return strings.Join(parts, "\x1f")
+	}
+}
+
+// toleranceDiffee considers numbers equal if they differ by up to tolerance.
+// Lines that aren't numbers must be equal. Numbers are sorted on the first
+// query, so lines can't be added after that.
+type toleranceDiffee struct {
+	tolerance float64
+	numbers   []float64
+	others    map[string]struct{}
+	sorted    sync.Once
+}
+
+func newToleranceDiffee(tolerance float64) *toleranceDiffee {
+	return &toleranceDiffee{tolerance: tolerance, others: make(map[string]struct{})}
+}
+
+func (d *toleranceDiffee) add(s string) {
+	if r, ok := parseNumber(s); ok {
+		f, _ := r.Float64()
+		d.numbers = append(d.numbers, f)
+		return
+	}
+	d.others[s] = struct{}{}
+}
+
+func (d *toleranceDiffee) contains(s string) bool {
+	d.sorted.Do(func() { sort.Float64s(d.numbers) })
+
+	r, ok := parseNumber(s)
+	if !ok {
+		_, ok := d.others[s]
+		return ok
+	}
+
+	f, _ := r.Float64()
+	i := sort.SearchFloat64s(d.numbers, f-d.tolerance)
+	return i < len(d.numbers) && d.numbers[i] <= f+d.tolerance
+}
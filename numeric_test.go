@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNumericKey(t *testing.T) {
+	key := numericKey(fieldsKey([]int{1, 2}, ","))
+	tests := map[string]string{
+		"007,1.0":     "7\x1f1",
+		"7,1":         "7\x1f1",
+		" +7 ,1e0":    "7\x1f1",
+		"-0.50,abc":   "-1/2\x1fabc",
+		"0x10,010/2":  "0x10\x1f010/2",
+		"1.2.3,.5000": "1.2.3\x1f1/2",
+	}
+
+	for line, expected := range tests {
+		if k := key(line); k != expected {
+			t.Errorf("key of %q wasn't %q, it was %q", line, expected, k)
+		}
+	}
+}
+
+func TestToleranceDiffee(t *testing.T) {
+	d := newToleranceDiffee(0.01)
+	for _, s := range []string{"3", "1.5", "-2", "n/a"} {
+		d.add(s)
+	}
+
+	tests := map[string]bool{
+		"1.505": true,
+		"1.49":  true,
+		"1.52":  false,
+		"-2.01": true,
+		"3":     true,
+		"0":     false,
+		"n/a":   true,
+		"N/A":   false,
+	}
+	for s, expected := range tests {
+		if d.contains(s) != expected {
+			t.Errorf("contains(%q) wasn't %v", s, expected)
+		}
+	}
+}
+
+func TestDiffNumeric(t *testing.T) {
+	stdout := make(chan result)
+	reader := strings.NewReader("007\n8\n1.0\n2.50\n")
+	key := numericKey(wholeLine)
+
+	go diff(`echo -e "7\n1\n2.5"`, keyedDiffee{&listDiffee{}, key, key}, defaultTimeout(), defaultTimeout(), stdout, mockUtils{reader}, false, nil)
+
+	lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{}.format)
+
+	if reflect.DeepEqual(lines, []string{"8"}) != true {
+		t.Errorf("result wasn't ['8'], it was %v", lines)
+	}
+}
+
+func TestToleranceWithDecimals(t *testing.T) {
+	lines := runSd(t, "1.5\n10\n", "-t", "1", "--tolerance", "0.1", `printf "1.52\n10.05\n"`)
+	if !reflect.DeepEqual(lines, []string{}) {
+		t.Errorf("result wasn't [], it was %v", lines)
+	}
+
+	lines = runSd(t, "a\t1.5\nb\t10\n", "-t", "1", "--key", "2", "--tolerance", "0.01", `printf "x\t1.505\ny\t10.05\n"`)
+	if !reflect.DeepEqual(lines, []string{"b\t10"}) {
+		t.Errorf("result wasn't ['b\\t10'], it was %v", lines)
+	}
+}
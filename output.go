@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// result is an output record. side is "left" if it's only in STDIN, "right" if
+// it's only in the command, "common" if it's in both or "changed" if it's in
+// both with other fields changed, and n is its number in its stream starting
+// at 1, or 0 if it's unknown. done is called once it's written, if it's set.
+type result struct {
+	line    string
+	side    string
+	n       int
+	match   string
+	changed []string
+	done    func()
+}
+
+// templateData is what --template can output for each result.
+type templateData struct {
+	Line    string
+	Key     string
+	Fields  []string
+	Side    string
+	Number  int
+	Match   string
+	Changed []string
+	Time    time.Time
+}
+
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	// sql escapes backslashes too, as MySQL treats them as escapes by
+	// default, and ansisql doesn't, for databases like PostgreSQL and SQLite
+	"sql": func(s string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, "'", "''").Replace(s) + "'"
+	},
+	"ansisql": func(s string) string {
+		return "'" + strings.Replace(s, "'", "''", -1) + "'"
+	},
+}
+
+func parseTemplate(text string) (*template.Template, error) {
+	return template.New("").Funcs(templateFuncs).Parse(text)
+}
+
+// formatter formats results as text, with template if it's set. Otherwise,
+// results are preceded by their number if numbers is set and their side if
+// sides is set, and followed by their match if matches is set.
+type formatter struct {
+	template  *template.Template
+	separator string
+	numbers   bool
+	sides     bool
+	matches   bool
+	stdin     streamOptions
+	cmd       streamOptions
+}
+
+func (f formatter) format(r result) string {
+	if f.template == nil {
+		s := r.line
+		if f.sides {
+			s = r.side + f.separator + s
+			if len(r.changed) > 0 {
+				s += f.separator + strings.Join(r.changed, ",")
+			}
+		}
+		if f.matches {
+			s += f.separator + r.match
+		}
+		if f.numbers && r.n > 0 {
+			s = strconv.Itoa(r.n) + ":" + s
+		}
+		return s
+	}
+
+	so := f.stdin
+	if r.side == "right" {
+		so = f.cmd
+	}
+	data := templateData{
+		Line:    r.line,
+		Key:     strings.Replace(so.key()(r.line), "\x1f", f.separator, -1),
+		Fields:  so.fields(r.line),
+		Side:    r.side,
+		Number:  r.n,
+		Match:   r.match,
+		Changed: r.changed,
+		Time:    time.Now(),
+	}
+
+	var b bytes.Buffer
+	if err := f.template.Execute(&b, data); err != nil {
+		log.Fatal(err)
+	}
+	return b.String()
+}
+
+// fields splits a record into CSV fields, or by the field separator.
+func (so streamOptions) fields(line string) []string {
+	if so.csv != nil {
+		fields, err := csv.NewReader(strings.NewReader(line)).Read()
+		if err != nil {
+			return []string{line}
+		}
+		return fields
+	}
+	if so.fieldSeparator == "" {
+		return []string{line}
+	}
+	return strings.Split(line, so.fieldSeparator)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"text/template"
+)
+
+func TestFormatWithoutTemplate(t *testing.T) {
+	tests := []struct {
+		f        formatter
+		r        result
+		expected string
+	}{
+		{formatter{}, result{line: "a", side: "left", match: "b"}, "a"},
+		{formatter{separator: "\t", matches: true}, result{line: "a", match: "b"}, "a\tb"},
+		{formatter{separator: ",", sides: true}, result{line: "a", side: "right"}, "right,a"},
+		{formatter{separator: ",", sides: true}, result{line: "a,b", side: "changed", changed: []string{"1", "2"}}, "changed,a,b,1,2"},
+	}
+
+	for _, ts := range tests {
+		if s := ts.f.format(ts.r); s != ts.expected {
+			t.Errorf("result wasn't %q, it was %q", ts.expected, s)
+		}
+	}
+}
+
+func TestFormatWithTemplate(t *testing.T) {
+	stdin := streamOptions{keyFields: []int{2, 1}, fieldSeparator: "\t"}
+	cmd := streamOptions{keyFields: []int{1, 2}, fieldSeparator: "\t"}
+	f := formatter{separator: "\t", stdin: stdin, cmd: cmd}
+
+	tests := []struct {
+		template string
+		r        result
+		expected string
+	}{
+		{`{{.Number}}: {{.Line}} ({{.Side}})`, result{line: "a\tb", side: "left", n: 3}, "3: a\tb (left)"},
+		{`{{.Key}}`, result{line: "a\tb\tc", side: "left"}, "b\ta"},
+		{`{{.Key}}`, result{line: "a\tb\tc", side: "right"}, "a\tb"},
+		{`{{index .Fields 2}}`, result{line: "a\tb\tc"}, "c"},
+		{`{{.Line}} -> {{or .Match "none"}}`, result{line: "a", match: "b"}, "a -> b"},
+		{`{{.Line}} -> {{or .Match "none"}}`, result{line: "a"}, "a -> none"},
+		{`{{join .Changed ","}}`, result{line: "a", changed: []string{"2", "3"}}, "2,3"},
+		{`INSERT INTO t VALUES ({{sql .Line}});`, result{line: "O'Brien"}, "INSERT INTO t VALUES ('O''Brien');"},
+		{`{{sql .Line}}`, result{line: `\' OR 1=1 -- `}, `'\\'' OR 1=1 -- '`},
+		{`{{ansisql .Line}}`, result{line: `\' OR 1=1 -- `}, `'\'' OR 1=1 -- '`},
+		{`{"line": {{json .Line}}}`, result{line: "say \"hi\""}, `{"line": "say \"hi\""}`},
+		{`{{if .Time.IsZero}}zero{{else}}now{{end}}`, result{line: "a"}, "now"},
+	}
+
+	for _, ts := range tests {
+		f.template = template.Must(parseTemplate(ts.template))
+		if s := f.format(ts.r); s != ts.expected {
+			t.Errorf("%q result wasn't %q, it was %q", ts.template, ts.expected, s)
+		}
+	}
+}
+
+func TestFieldsOfCSVRecords(t *testing.T) {
+	so := streamOptions{csv: newCSVHeader("STDIN", nil)}
+
+	fields := so.fields(`1,"Doe, Jane"`)
+	if len(fields) != 2 || fields[1] != "Doe, Jane" {
+		t.Errorf("fields weren't ['1', 'Doe, Jane'], they were %q", fields)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"sync"
+)
+
+// patternsPerRegex is how many patterns are combined into a regex at most.
+// Groups that are still too large for a regex are split in halves.
+const patternsPerRegex = 1000
+
+// patternDiffee treats each line as a glob or a regex, and contains the lines
+// that match any of them. The patterns are combined into a few regexes on the
+// first query, so lines can't be added after that.
+type patternDiffee struct {
+	glob     bool
+	patterns []string
+	compiled sync.Once
+	res      []*regexp.Regexp
+}
+
+func newPatternDiffee(glob bool) *patternDiffee {
+	return &patternDiffee{glob: glob}
+}
+
+func (d *patternDiffee) add(s string) {
+	if d.glob {
+		s = globToRegex(s)
+	}
+	if _, err := regexp.Compile(s); err != nil {
+		log.Fatal(fmt.Errorf("invalid pattern %q: %v", s, err))
+	}
+	d.patterns = append(d.patterns, s)
+}
+
+func (d *patternDiffee) contains(s string) bool {
+	d.compiled.Do(func() {
+		for i := 0; i < len(d.patterns); i += patternsPerRegex {
+			j := i + patternsPerRegex
+			if j > len(d.patterns) {
+				j = len(d.patterns)
+			}
+			res, err := combinePatterns(d.patterns[i:j])
+			if err != nil {
+				log.Fatal(err)
+			}
+			d.res = append(d.res, res...)
+		}
+	})
+
+	for _, re := range d.res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// combinePatterns compiles the patterns into a regex that matches any of them,
+// or into several if a single one would be too large.
+func combinePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	re, err := regexp.Compile("(?:" + strings.Join(patterns, ")|(?:") + ")")
+	var syntaxErr *syntax.Error
+	if errors.As(err, &syntaxErr) && syntaxErr.Code == syntax.ErrLarge && len(patterns) > 1 {
+		first, err := combinePatterns(patterns[:len(patterns)/2])
+		if err != nil {
+			return nil, err
+		}
+		rest, err := combinePatterns(patterns[len(patterns)/2:])
+		if err != nil {
+			return nil, err
+		}
+		return append(first, rest...), nil
+	}
+	if err != nil {
+		if len(patterns) == 1 {
+			return nil, fmt.Errorf("invalid pattern %q: %v", patterns[0], err)
+		}
+		return nil, fmt.Errorf("can't combine %v patterns: %v", len(patterns), err)
+	}
+
+	return []*regexp.Regexp{re}, nil
+}
+
+// globToRegex converts a glob to an anchored regex. * matches any string, ?
+// any character, and [...] or [!...] a character in or out of a class.
+func globToRegex(glob string) string {
+	var b bytes.Buffer
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			j := strings.IndexByte(glob[i+1:], ']')
+			if j < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+j]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += j + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+
+	return b.String()
+}
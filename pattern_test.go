@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGlobToRegex(t *testing.T) {
+	tests := map[string]string{
+		"*.internal.example.com": `^.*\.internal\.example\.com$`,
+		"test_?":                 `^test_.$`,
+		"[!a-c]x[0-9]":           `^[^a-c]x[0-9]$`,
+		"a[b":                    `^a\[b$`,
+		"(1+1)":                  `^\(1\+1\)$`,
+	}
+
+	for glob, expected := range tests {
+		if re := globToRegex(glob); re != expected {
+			t.Errorf("regex of %q wasn't %q, it was %q", glob, expected, re)
+		}
+	}
+}
+
+func TestPatternDiffee(t *testing.T) {
+	globs := newPatternDiffee(true)
+	globs.add("*.internal.example.com")
+	globs.add("db?.example.com")
+
+	regexes := newPatternDiffee(false)
+	regexes.add("^test_")
+	regexes.add(`\d{3}$`)
+
+	tests := []struct {
+		d        *patternDiffee
+		line     string
+		expected bool
+	}{
+		{globs, "api.internal.example.com", true},
+		{globs, "internal.example.com", false},
+		{globs, "db1.example.com", true},
+		{globs, "db10.example.com", false},
+		{regexes, "test_users", true},
+		{regexes, "users_test_", false},
+		{regexes, "users_123", true},
+		{newPatternDiffee(false), "anything", false},
+	}
+	for _, ts := range tests {
+		if ts.d.contains(ts.line) != ts.expected {
+			t.Errorf("contains(%q) wasn't %v", ts.line, ts.expected)
+		}
+	}
+}
+
+func TestPatternDiffeeWithManyPatterns(t *testing.T) {
+	// each pattern is thousands of instructions, too many for a single regex
+	d := newPatternDiffee(false)
+	for i := 0; i < 800; i++ {
+		d.add(fmt.Sprintf("^test_%v_[a-z]{1,1000}_[0-9]{1,1000}_[a-z]{1,1000}$", i))
+	}
+
+	if !d.contains("test_799_users_1_b") || d.contains("test_800_users_1_b") {
+		t.Errorf("the patterns weren't matched")
+	}
+	if len(d.res) < 2 {
+		t.Errorf("the patterns weren't split into several regexes")
+	}
+}
+
+func TestDiffAgainstGlobs(t *testing.T) {
+	stdout := make(chan result)
+	reader := strings.NewReader("www.example.com\napi.internal.example.com\nexample.org\n")
+
+	go diff(`echo -e "*.internal.example.com\n*.org"`, newPatternDiffee(true), defaultTimeout(), defaultTimeout(), stdout, mockUtils{reader}, false, nil)
+
+	lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{}.format)
+
+	if reflect.DeepEqual(lines, []string{"www.example.com"}) != true {
+		t.Errorf("result wasn't ['www.example.com'], it was %v", lines)
+	}
+}
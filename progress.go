@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// counters are updated atomically while sd runs, to report its progress.
+type counters struct {
+	stdin      streamCounters
+	cmd        streamCounters
+	diffeeSize int64
+	pending    int64
+	matched    int64
+	emitted    int64
+}
+
+type streamCounters struct {
+	lines         int64
+	timeoutResets int64
+}
+
+var stats counters
+
+// countingDiffee counts the lines added to the diffee.
+type countingDiffee struct {
+	diffee
+}
+
+func (d countingDiffee) add(s string) {
+	atomic.AddInt64(&stats.diffeeSize, 1)
+	d.diffee.add(s)
+}
+
+// progress is a line describing the counters and when the timeouts fire.
+func progress(c *counters, stdinTimeout, cmdTimeout timeout) string {
+	return fmt.Sprintf(
+		"STDIN: %v lines, timeout %v | command: %v lines, timeout %v | diffee: %v lines | pending: %v | output: %v",
+		atomic.LoadInt64(&c.stdin.lines),
+		untilDeadline(stdinTimeout.deadline),
+		atomic.LoadInt64(&c.cmd.lines),
+		untilDeadline(cmdTimeout.deadline),
+		atomic.LoadInt64(&c.diffeeSize),
+		atomic.LoadInt64(&c.pending),
+		atomic.LoadInt64(&c.emitted),
+	)
+}
+
+func untilDeadline(d *deadline) string {
+	t, ok := d.get()
+	if !ok {
+		return "none"
+	}
+	if until := time.Until(t); until > 0 {
+		return until.Truncate(100 * time.Millisecond).String()
+	}
+	return "fired"
+}
+
+// showProgress rewrites the progress line in w every interval until stop is
+// closed, and then writes it for the last time followed by a newline.
+func showProgress(w io.Writer, interval time.Duration, stdinTimeout, cmdTimeout timeout, stop, stopped chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			fmt.Fprintf(w, "\r%v\x1b[K", progress(&stats, stdinTimeout, cmdTimeout))
+		case <-stop:
+			fmt.Fprintf(w, "\r%v\x1b[K\n", progress(&stats, stdinTimeout, cmdTimeout))
+			close(stopped)
+			return
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgress(t *testing.T) {
+	c := counters{stdin: streamCounters{lines: 10}, cmd: streamCounters{lines: 20}, diffeeSize: 25, pending: 3, emitted: 7}
+	stdinTimeout := timeout{deadline: &deadline{}}
+	cmdTimeout := timeout{deadline: &deadline{}}
+	stdinTimeout.deadline.set(time.Now().Add(5 * time.Second))
+	cmdTimeout.deadline.set(time.Now().Add(-time.Second))
+
+	expected := "STDIN: 10 lines, timeout 4.9s | command: 20 lines, timeout fired | diffee: 25 lines | pending: 3 | output: 7"
+	if p := progress(&c, stdinTimeout, cmdTimeout); p != expected {
+		t.Errorf("progress wasn't %q, it was %q", expected, p)
+	}
+}
+
+func TestTimeoutDeadline(t *testing.T) {
+	to := timeout{firstTime: time.Minute, time: time.Hour, deadline: &deadline{}}
+	if untilDeadline(to.deadline) != "none" {
+		t.Errorf("a timeout that didn't start shouldn't have a deadline")
+	}
+
+	to.Start()
+	if d, _ := to.deadline.get(); time.Until(d) > time.Minute || time.Until(d) < 59*time.Second {
+		t.Errorf("the deadline should be in a minute, but it's at %v", d)
+	}
+	to.Reset()
+	if d, _ := to.deadline.get(); time.Until(d) < 59*time.Minute {
+		t.Errorf("the deadline should be in an hour, but it's at %v", d)
+	}
+
+	infinite := timeout{infinite: true, deadline: &deadline{}}
+	infinite.Start()
+	if untilDeadline(infinite.deadline) != "none" {
+		t.Errorf("an infinite timeout shouldn't have a deadline")
+	}
+}
+
+func TestShowProgress(t *testing.T) {
+	var b bytes.Buffer
+	stop, stopped := make(chan struct{}), make(chan struct{})
+
+	go showProgress(&b, time.Hour, timeout{}, timeout{}, stop, stopped)
+	close(stop)
+	<-stopped
+
+	if !strings.HasPrefix(b.String(), "\rSTDIN: ") || !strings.HasSuffix(b.String(), "\x1b[K\n") {
+		t.Errorf("the last progress line wasn't shown, the output was %q", b.String())
+	}
+}
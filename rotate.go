@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileSink writes to a temporary file that's renamed to its path when it's
+// closed, so the path never has partial output. If size or interval are set,
+// the file is also closed and replaced by a new one once it has the specified
+// bytes, or once it's been open for the specified time. Paths are pattern,
+// with %n replaced by the number of the file (starting after the highest
+// existing one, or at 0) and %t by the time it was opened.
+type fileSink struct {
+	sync.Mutex
+	pattern  string
+	gzipped  bool
+	size     int64
+	interval time.Duration
+
+	n       int
+	tmp     *os.File
+	gz      *gzip.Writer
+	written int64
+	opened  time.Time
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func newFileSink(pattern string, gzipped bool, size int64, interval time.Duration) (*fileSink, error) {
+	f := &fileSink{pattern: pattern, gzipped: gzipped, size: size, interval: interval, stop: make(chan struct{}), stopped: make(chan struct{})}
+	if strings.Contains(pattern, "%n") {
+		n, err := nextFileNumber(pattern)
+		if err != nil {
+			return nil, err
+		}
+		f.n = n
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	if interval > 0 {
+		go f.rotateEvery(interval)
+	}
+
+	return f, nil
+}
+
+// expandPattern replaces %n by n, %t by t and %% by %.
+func expandPattern(pattern string, n int, t time.Time) string {
+	return strings.NewReplacer("%n", strconv.Itoa(n), "%t", t.Format("20060102T150405"), "%%", "%").Replace(pattern)
+}
+
+// nextFileNumber returns the number after the highest one of the existing
+// files that match pattern, so that the files of previous runs aren't
+// overwritten.
+func nextFileNumber(pattern string) (int, error) {
+	var glob, re bytes.Buffer
+	re.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "%n"):
+			glob.WriteString("*")
+			re.WriteString(`(\d+)`)
+			i++
+		case strings.HasPrefix(pattern[i:], "%t"):
+			glob.WriteString("*")
+			re.WriteString(`\d{8}T\d{6}`)
+			i++
+		case strings.HasPrefix(pattern[i:], "%%"):
+			glob.WriteString("%")
+			re.WriteString("%")
+			i++
+		default:
+			if strings.IndexByte(`*?[\`, pattern[i]) >= 0 {
+				glob.WriteByte('\\')
+			}
+			glob.WriteByte(pattern[i])
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	re.WriteString("$")
+
+	paths, err := filepath.Glob(glob.String())
+	if err != nil {
+		return 0, err
+	}
+	numbered := regexp.MustCompile(re.String())
+	next := 0
+	for _, path := range paths {
+		m := numbered.FindStringSubmatch(path)
+		if m == nil {
+			continue
+		}
+		if n, err := strconv.Atoi(m[1]); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	return next, nil
+}
+
+func (f *fileSink) path() string {
+	return expandPattern(f.pattern, f.n, f.opened)
+}
+
+func (f *fileSink) open() error {
+	f.opened = time.Now()
+	path := f.path()
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	f.tmp = tmp
+	f.written = 0
+	if f.gzipped {
+		f.gz = gzip.NewWriter(tmp)
+	}
+	return nil
+}
+
+// finish renames the temporary file to its path, once it's synced.
+func (f *fileSink) finish() error {
+	if f.gz != nil {
+		if err := f.gz.Close(); err != nil {
+			return err
+		}
+	}
+	if err := f.tmp.Chmod(0644); err != nil {
+		return err
+	}
+	return renameSynced(f.tmp, f.path())
+}
+
+func (f *fileSink) rotate() error {
+	if err := f.finish(); err != nil {
+		return err
+	}
+	f.n++
+	return f.open()
+}
+
+func (f *fileSink) rotateEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval / 10)
+	defer ticker.Stop()
+	defer close(f.stopped)
+
+	for {
+		select {
+		case <-ticker.C:
+			f.Lock()
+			if f.written > 0 && time.Since(f.opened) >= interval {
+				if err := f.rotate(); err != nil {
+					log.Fatal(err)
+				}
+			}
+			f.Unlock()
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+// Write writes p to the current file, first rotating it if p would make it
+// longer than size. Records are written with a single call, so they're never
+// split between files.
+func (f *fileSink) Write(p []byte) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.size > 0 && f.written > 0 && f.written+int64(len(p)) > f.size {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	var w io.Writer = f.tmp
+	if f.gz != nil {
+		w = f.gz
+	}
+	n, err := w.Write(p)
+	f.written += int64(n)
+	return n, err
+}
+
+func (f *fileSink) Flush() error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.gz != nil {
+		return f.gz.Flush()
+	}
+	return nil
+}
+
+// Close waits for the rotation to stop, as a tick can still be handled after
+// stop is closed.
+func (f *fileSink) Close() error {
+	close(f.stop)
+	if f.interval > 0 {
+		<-f.stopped
+	}
+	f.Lock()
+	defer f.Unlock()
+
+	return f.finish()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestExpandPattern(t *testing.T) {
+	tm := time.Date(2017, 3, 4, 5, 6, 7, 0, time.UTC)
+	if s := expandPattern("out-%n-%t-100%%.txt", 3, tm); s != "out-3-20170304T050607-100%.txt" {
+		t.Errorf("pattern wasn't expanded correctly: %q", s)
+	}
+}
+
+func TestFileSinkContinuesNumbering(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"out.0", "out.7", "out.x", "other.9"} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte("old\n"), 0644)
+	}
+	f, err := newFileSink(filepath.Join(dir, "out.%n"), false, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(f, "new\n")
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "out.0")); err != nil || string(b) != "old\n" {
+		t.Errorf("out.0 was overwritten: %q (%v)", b, err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "out.8")); err != nil || string(b) != "new\n" {
+		t.Errorf("out.8 wasn't 'new\\n', it was %q (%v)", b, err)
+	}
+}
+
+func TestFileSinkIsOnlyWrittenOnClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "out.txt")
+	f, err := newFileSink(path, false, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(f, "1\n")
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("%v shouldn't exist before closing", path)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(path); err != nil || string(b) != "1\n" {
+		t.Errorf("file wasn't '1\\n', it was %q (%v)", b, err)
+	}
+}
+
+func TestFileSinkRotatesBySize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := newFileSink(filepath.Join(dir, "out.%n.gz"), true, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"1\n", "2\n", "3\n", "long\n", "4\n"} {
+		io.WriteString(f, s)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	names, _ := filepath.Glob(filepath.Join(dir, "*"))
+	sort.Strings(names)
+	expected := []string{"1\n2\n", "3\n", "long\n", "4\n"}
+	if len(names) != len(expected) {
+		t.Fatalf("there should be %v files, but there are %q", len(expected), names)
+	}
+	for i, s := range expected {
+		file, err := os.Open(filepath.Join(dir, "out."+string('0'+rune(i))+".gz"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := ioutil.ReadAll(r); string(b) != s {
+			t.Errorf("file %v wasn't %q, it was %q", i, s, b)
+		}
+		file.Close()
+	}
+}
+
+func TestFileSinkRotatesByInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := newFileSink(filepath.Join(dir, "out.%n"), false, 0, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(f, "1\n")
+	time.Sleep(300 * time.Millisecond)
+	io.WriteString(f, "2\n")
+	f.Close()
+
+	for i, s := range []string{"1\n", "2\n"} {
+		if b, err := ioutil.ReadFile(filepath.Join(dir, "out."+string('0'+rune(i)))); err != nil || string(b) != s {
+			t.Errorf("file %v wasn't %q, it was %q (%v)", i, s, b, err)
+		}
+	}
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// deniedOptions can't be requested, as they read or write the server's
+// files or ports.
+var deniedOptions = map[string]bool{
+	"index":           true,
+	"bloom":           true,
+	"state":           true,
+	"checkpoint":      true,
+	"resume":          true,
+	"sink":            true,
+	"output":          true,
+	"rotate-size":     true,
+	"rotate-interval": true,
+	"progress":        true,
+	"metrics":         true,
+	"spill":           true,
+}
+
+var (
+	errBusy     = errors.New("too many jobs are running; see --max-jobs")
+	errTooLarge = errors.New("the request body is too large; see --max-body-size")
+	errIdle     = errors.New("the job's streams weren't uploaded in time; see --upload-timeout")
+)
+
+// server runs the diffs requested over HTTP. Each job is an sd process whose
+// STDIN and command are the uploaded streams, so it diffs them exactly like
+// sd does, and a job that fails can't take the others down.
+type server struct {
+	sd            string
+	maxBodySize   int64
+	uploadTimeout time.Duration
+	slots         chan struct{}
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+func newServer(sd string, maxJobs int, maxBodySize int64, uploadTimeout time.Duration) *server {
+	return &server{
+		sd:            sd,
+		maxBodySize:   maxBodySize,
+		uploadTimeout: uploadTimeout,
+		slots:         make(chan struct{}, maxJobs),
+		jobs:          map[string]*job{},
+	}
+}
+
+// job is an sd process. The parent ends of its pipes are closed by whoever
+// claims them, or once it exits if nobody did.
+type job struct {
+	cmd     *exec.Cmd
+	stdin   *os.File
+	command *os.File
+	output  *os.File
+	stderr  bytes.Buffer
+	done    chan struct{}
+
+	mu      sync.Mutex
+	err     error
+	claimed map[string]bool
+}
+
+// optionArgs returns the sd arguments of the options in a request's query,
+// e.g. ?intersection&key=2 is --intersection --key=2.
+func optionArgs(query url.Values) ([]string, error) {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var args []string
+	for _, name := range names {
+		if deniedOptions[name] {
+			return nil, fmt.Errorf("--%v can't be used with sd serve", name)
+		}
+		for _, v := range query[name] {
+			if v == "" {
+				args = append(args, "--"+name)
+			} else {
+				args = append(args, "--"+name+"="+v)
+			}
+		}
+	}
+
+	// parsed quietly first, so that bad requests don't print the usage
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	defineOptions(fs)
+	fs.SetOutput(ioutil.Discard)
+	fs.Usage = func() {}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if _, err := resolveOptions(args); err != nil {
+		return nil, err
+	}
+
+	return args, nil
+}
+
+// start runs sd with args, reading the command from file descriptor 3.
+func (s *server) start(args []string) (*job, error) {
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		return nil, errBusy
+	}
+
+	var err error
+	pipe := func() (*os.File, *os.File) {
+		r, w, e := os.Pipe()
+		if err == nil {
+			err = e
+		}
+		return r, w
+	}
+	stdin, stdinW := pipe()
+	command, commandW := pipe()
+	outputR, output := pipe()
+
+	j := &job{stdin: stdinW, command: commandW, output: outputR, done: make(chan struct{}), claimed: map[string]bool{}}
+	if err == nil {
+		j.cmd = exec.Command(s.sd, append(args, "cat <&3")...)
+		j.cmd.Stdin, j.cmd.Stdout, j.cmd.Stderr = stdin, output, &j.stderr
+		j.cmd.ExtraFiles = []*os.File{command}
+		err = j.cmd.Start()
+	}
+	// closing a nil file just returns an error
+	stdin.Close()
+	command.Close()
+	output.Close()
+	if err != nil {
+		j.stdin.Close()
+		j.command.Close()
+		j.output.Close()
+		<-s.slots
+		return nil, err
+	}
+
+	go func() {
+		err := j.cmd.Wait()
+		j.mu.Lock()
+		if j.err == nil && err != nil {
+			j.err = fmt.Errorf("%v: %v", err, strings.TrimSpace(j.stderr.String()))
+		}
+		j.mu.Unlock()
+		close(j.done)
+		<-s.slots
+	}()
+	return j, nil
+}
+
+// claim returns the file of a job's stream ("stdin", "command" or
+// "output"), which can only be claimed once.
+func (j *job) claim(stream string) (*os.File, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.claimed[stream] {
+		return nil, false
+	}
+	j.claimed[stream] = true
+	return map[string]*os.File{"stdin": j.stdin, "command": j.command, "output": j.output}[stream], true
+}
+
+// uploading returns whether both of the job's streams are being uploaded.
+func (j *job) uploading() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.claimed["stdin"] && j.claimed["command"]
+}
+
+// closeUnclaimed closes the streams that were never claimed, so they can't be
+// claimed anymore.
+func (j *job) closeUnclaimed() {
+	for _, stream := range []string{"stdin", "command", "output"} {
+		if f, ok := j.claim(stream); ok {
+			f.Close()
+		}
+	}
+}
+
+// fail kills the job, which is reported with err.
+func (j *job) fail(err error) {
+	j.mu.Lock()
+	if j.err == nil {
+		j.err = err
+	}
+	j.mu.Unlock()
+	j.cmd.Process.Kill()
+}
+
+func (j *job) error() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
+
+// upload copies body to the stream, and closes it. The job fails if body
+// can't be read, but not if sd stops reading it, e.g. once it times out.
+func (j *job) upload(f *os.File, body io.Reader) error {
+	r := &errReader{r: body}
+	io.Copy(f, r)
+	f.Close()
+	if r.err != nil {
+		j.fail(r.err)
+	}
+	return r.err
+}
+
+// limitedReader fails with errTooLarge after n bytes. Unlike
+// http.MaxBytesReader, it can be read while the response is written.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return 0, errTooLarge
+	}
+	return n, err
+}
+
+// peekBody starts reading body before the response is written, as clients
+// that wait for "100 Continue" can't send it afterwards.
+func peekBody(body io.Reader) io.Reader {
+	r := bufio.NewReader(body)
+	r.Peek(1)
+	return r
+}
+
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// streamOutput writes the job's output to w as it's produced, followed by the
+// Sd-Error trailer if the job failed.
+func (j *job) streamOutput(w http.ResponseWriter, output *os.File) {
+	rc := http.NewResponseController(w)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := output.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				j.fail(err)
+				break
+			}
+			rc.Flush()
+		}
+		if err != nil {
+			break
+		}
+	}
+	output.Close()
+
+	<-j.done
+	if err := j.error(); err != nil {
+		w.Header().Set("Sd-Error", err.Error())
+	}
+}
+
+// startResponse enables reading the request while its response is written.
+func startResponse(w http.ResponseWriter) {
+	http.NewResponseController(w).EnableFullDuplex()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Trailer", "Sd-Error")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/diff", s.serveDiff)
+	mux.HandleFunc("/jobs", s.serveNewJob)
+	mux.HandleFunc("/jobs/", s.serveUpload)
+	return mux
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+// startJob starts a job with the options in the request's query, or replies
+// with an error.
+func (s *server) startJob(w http.ResponseWriter, r *http.Request) *job {
+	args, err := optionArgs(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+
+	j, err := s.start(args)
+	if err == errBusy {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return nil
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	return j
+}
+
+// serveDiff diffs the "stdin" part of a multipart request against its
+// "command" part, and replies with the output. Parts are read in order, so
+// the second one must start before its --patience.
+func (s *server) serveDiff(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, "POST") {
+		return
+	}
+	r.Body = ioutil.NopCloser(&limitedReader{peekBody(r.Body), s.maxBodySize})
+	parts, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	j := s.startJob(w, r)
+	if j == nil {
+		return
+	}
+	stdin, _ := j.claim("stdin")
+	command, _ := j.claim("command")
+	output, _ := j.claim("output")
+
+	uploaded := make(chan struct{})
+	go func() {
+		defer close(uploaded)
+		defer stdin.Close()
+		defer command.Close()
+		for {
+			part, err := parts.NextPart()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				j.fail(err)
+				return
+			}
+
+			switch part.FormName() {
+			case "stdin":
+				err = j.upload(stdin, part)
+			case "command":
+				err = j.upload(command, part)
+			default:
+				err = fmt.Errorf("unknown part %q", part.FormName())
+				j.fail(err)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	startResponse(w)
+	j.streamOutput(w, output)
+	<-uploaded
+}
+
+// serveNewJob starts a job whose streams are uploaded to /jobs/ID/command and
+// /jobs/ID/stdin, and replies with its ID.
+func (s *server) serveNewJob(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, "POST") {
+		return
+	}
+	j := s.startJob(w, r)
+	if j == nil {
+		return
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+
+	s.mu.Lock()
+	s.jobs[id] = j
+	s.mu.Unlock()
+	// otherwise a job that's never uploaded could hold its slot forever
+	idle := time.AfterFunc(s.uploadTimeout, func() {
+		if !j.uploading() {
+			j.fail(errIdle)
+		}
+	})
+	go func() {
+		<-j.done
+		idle.Stop()
+		s.mu.Lock()
+		delete(s.jobs, id)
+		s.mu.Unlock()
+		j.closeUnclaimed()
+	}()
+
+	w.Header().Set("Location", "/jobs/"+id)
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(w, id)
+}
+
+// serveUpload streams the request body to a job's stream. STDIN's upload is
+// replied with the output.
+func (s *server) serveUpload(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, "PUT") {
+		return
+	}
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+	if len(path) != 2 || (path[1] != "command" && path[1] != "stdin") {
+		http.NotFound(w, r)
+		return
+	}
+	stream := path[1]
+
+	s.mu.Lock()
+	j := s.jobs[path[0]]
+	s.mu.Unlock()
+	if j == nil {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	f, ok := j.claim(stream)
+	if !ok {
+		http.Error(w, stream+" was already uploaded", http.StatusConflict)
+		return
+	}
+	body := &limitedReader{peekBody(r.Body), s.maxBodySize}
+
+	if stream == "command" {
+		if err := j.upload(f, body); err != nil {
+			if err == errTooLarge {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			} else {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	output, ok := j.claim("output")
+	if !ok {
+		f.Close()
+		http.Error(w, "the output was already claimed", http.StatusConflict)
+		return
+	}
+	uploaded := make(chan struct{})
+	go func() {
+		j.upload(f, body)
+		close(uploaded)
+	}()
+
+	startResponse(w)
+	j.streamOutput(w, output)
+	<-uploaded
+}
+
+func mustRunServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("listen", ":8080", "the address to listen on.")
+	maxJobs := fs.Int("max-jobs", 8, "the maximum number of jobs running at once.")
+	maxBodySize := fs.Int64("max-body-size", 1<<30, "the maximum size of a request body.")
+	uploadTimeout := fs.Int("upload-timeout", 60, "the seconds a job created with POST /jobs waits for both of its streams to start uploading.")
+	fs.Usage = usage
+	fs.Parse(args)
+
+	if fs.NArg() != 0 || *maxJobs < 1 || *maxBodySize < 1 || *uploadTimeout < 1 {
+		usage()
+		os.Exit(1)
+	}
+
+	sd, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(http.ListenAndServe(*listen, newServer(sd, *maxJobs, *maxBodySize, time.Duration(*uploadTimeout)*time.Second).handler()))
+}
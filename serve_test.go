@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMain runs sd instead of the tests in the jobs started by the server.
+func TestMain(m *testing.M) {
+	if os.Getenv("SD_TEST_RUN_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func newTestServer(t *testing.T, maxJobs int, maxBodySize int64) *httptest.Server {
+	return newTestServerWithTimeout(t, maxJobs, maxBodySize, time.Minute)
+}
+
+func newTestServerWithTimeout(t *testing.T, maxJobs int, maxBodySize int64, uploadTimeout time.Duration) *httptest.Server {
+	os.Setenv("SD_TEST_RUN_MAIN", "1")
+	t.Cleanup(func() { os.Unsetenv("SD_TEST_RUN_MAIN") })
+
+	ts := httptest.NewServer(newServer(os.Args[0], maxJobs, maxBodySize, uploadTimeout).handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func sortedLines(b []byte) []string {
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	sort.Strings(lines)
+	return lines
+}
+
+func TestOptionArgs(t *testing.T) {
+	args, err := optionArgs(url.Values{"intersection": {""}, "key": {"2"}, "t": {"5"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(args, []string{"--intersection", "--key=2", "--t=5"}) {
+		t.Errorf("unexpected args %v", args)
+	}
+
+	for _, query := range []url.Values{
+		{"state": {"/etc/passwd"}},
+		{"spill": {"1000000"}},
+		{"unknown": {""}},
+		{"timeout": {"soon"}},
+		{"join": {"outer"}},
+	} {
+		if _, err := optionArgs(query); err == nil {
+			t.Errorf("%v should have been rejected", query)
+		}
+	}
+}
+
+func TestServeDiff(t *testing.T) {
+	ts := newTestServer(t, 1, 1024)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("command", "1\n3\n")
+	mw.WriteField("stdin", "1\n2\n3\n4\n")
+	mw.Close()
+
+	resp, err := http.Post(ts.URL+"/diff?t=1", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+
+	if lines := sortedLines(b); !reflect.DeepEqual(lines, []string{"2", "4"}) {
+		t.Errorf("result wasn't ['2', '4'], it was %v", lines)
+	}
+	if e := resp.Trailer.Get("Sd-Error"); e != "" {
+		t.Errorf("unexpected error %q", e)
+	}
+}
+
+func TestServeJob(t *testing.T) {
+	ts := newTestServer(t, 1, 1024)
+
+	resp, err := http.Post(ts.URL+"/jobs?intersection&t=1", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("creating the job failed with %v: %s", resp.Status, b)
+	}
+	id := strings.TrimSpace(string(b))
+
+	resp, err = http.Post(ts.URL+"/jobs?t=1", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("a job over --max-jobs should have been rejected, but got %v", resp.Status)
+	}
+
+	req, _ := http.NewRequest("PUT", ts.URL+"/jobs/"+id+"/command", strings.NewReader(strings.Repeat("x", 2048)))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("a body over --max-body-size should have been rejected, but got %v", resp.Status)
+	}
+
+}
+
+func TestServeDiffTooLarge(t *testing.T) {
+	ts := newTestServer(t, 1, 1024)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("command", strings.Repeat("x\n", 1024))
+	mw.WriteField("stdin", "1\n")
+	mw.Close()
+
+	resp, err := http.Post(ts.URL+"/diff?t=1", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+
+	if e := resp.Trailer.Get("Sd-Error"); !strings.Contains(e, "too large") {
+		t.Errorf("the job should have failed as the body was too large, but the error was %q", e)
+	}
+}
+
+func TestServeJobStreams(t *testing.T) {
+	ts := newTestServer(t, 1, 1024)
+
+	resp, err := http.Post(ts.URL+"/jobs?intersection&t=1", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	id := strings.TrimSpace(string(b))
+
+	uploaded := make(chan int)
+	go func() {
+		req, _ := http.NewRequest("PUT", ts.URL+"/jobs/"+id+"/command", strings.NewReader("1\n3\n"))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			uploaded <- 0
+			return
+		}
+		resp.Body.Close()
+		uploaded <- resp.StatusCode
+	}()
+
+	req, _ := http.NewRequest("PUT", ts.URL+"/jobs/"+id+"/stdin", strings.NewReader("1\n2\n3\n4\n"))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ = ioutil.ReadAll(resp.Body)
+
+	if status := <-uploaded; status != http.StatusNoContent {
+		t.Errorf("uploading the command should have replied 204, but got %v", status)
+	}
+	if lines := sortedLines(b); !reflect.DeepEqual(lines, []string{"1", "3"}) {
+		t.Errorf("result wasn't ['1', '3'], it was %v", lines)
+	}
+}
+
+func TestServeReapsIdleJobs(t *testing.T) {
+	ts := newTestServerWithTimeout(t, 1, 1024, 100*time.Millisecond)
+
+	for i, expected := range []int{http.StatusCreated, http.StatusServiceUnavailable} {
+		resp, err := http.Post(ts.URL+"/jobs?patience=0&follow&infinite", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != expected {
+			t.Fatalf("job %v should have replied %v, but got %v", i, expected, resp.Status)
+		}
+	}
+
+	for start := time.Now(); time.Since(start) < 2*time.Second; time.Sleep(50 * time.Millisecond) {
+		resp, err := http.Post(ts.URL+"/jobs?t=1", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusCreated {
+			return
+		}
+	}
+	t.Error("the idle job should have been killed, freeing its slot")
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// sink receives the results of one side, or of every side if side is "all".
+// Results without a side, like CSV headers, go to every sink. If color is set,
+// results are colored by side.
+type sink struct {
+	side  string
+	w     io.Writer
+	color bool
+}
+
+var sideColors = map[string]string{
+	"left":    "\x1b[31m",
+	"right":   "\x1b[32m",
+	"changed": "\x1b[33m",
+	"common":  "\x1b[2m",
+}
+
+func colorize(s, side string) string {
+	if c, ok := sideColors[side]; ok {
+		return c + s + "\x1b[0m"
+	}
+	return s
+}
+
+// colorSinks sets the color of the sinks, according to a --color of "auto",
+// "always" or "never". "auto" only colors STDOUT if it's a terminal.
+func colorSinks(sinks []sink, when string) {
+	for i := range sinks {
+		sinks[i].color = when == "always" || when == "auto" && sinks[i].w == os.Stdout && isTerminal(os.Stdout)
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+var sides = []string{"all", "left", "right", "common", "changed"}
+
+// parseSink parses a --sink, i.e. SIDE=PATH where PATH is - for STDOUT.
+func parseSink(s string) (side, path string, err error) {
+	i := strings.Index(s, "=")
+	if i < 0 || s[i+1:] == "" || indexOf(sides, s[:i]) < 0 {
+		return "", "", errors.New(`--sink must be SIDE=PATH, where SIDE is "all", "left", "right", "common" or "changed"`)
+	}
+	return s[:i], s[i+1:], nil
+}
+
+// mustOpenSinks opens the sinks, gzipping them if gzipped is set. A path can
+// be used by several sinks, and the path - is STDOUT. Files other than FIFOs
+// are written atomically, and rotated after size bytes or interval if they're
+// set; see fileSink. The returned function closes them all.
+func mustOpenSinks(specs []string, gzipped bool, size int64, interval time.Duration) ([]sink, func()) {
+	var sinks []sink
+	var closers []io.Closer
+	writers := make(map[string]io.Writer)
+
+	for _, spec := range specs {
+		side, path, err := parseSink(spec)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		w, ok := writers[path]
+		if !ok {
+			w, err = openSink(path, gzipped, size, interval)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if c, ok := w.(io.Closer); ok && w != os.Stdout {
+				closers = append(closers, c)
+			}
+			writers[path] = w
+		}
+		sinks = append(sinks, sink{side: side, w: w})
+	}
+
+	return sinks, func() {
+		for _, c := range closers {
+			if err := c.Close(); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+}
+
+func openSink(path string, gzipped bool, size int64, interval time.Duration) (io.Writer, error) {
+	var w io.WriteCloser = os.Stdout
+	if path != "-" {
+		if fi, err := os.Stat(path); err != nil || fi.Mode()&os.ModeNamedPipe == 0 {
+			return newFileSink(path, gzipped, size, interval)
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+
+	if gzipped {
+		return gzipCloser{gzip.NewWriter(w), w}, nil
+	}
+	return w, nil
+}
+
+// gzipCloser closes the gzip.Writer and then what it writes to.
+type gzipCloser struct {
+	*gzip.Writer
+	w io.WriteCloser
+}
+
+func (g gzipCloser) Close() error {
+	if err := g.Writer.Close(); err != nil {
+		return err
+	}
+	if g.w == os.Stdout {
+		return nil
+	}
+	return g.w.Close()
+}
+
+// printLn writes each result to the sinks of its side, followed by
+// delimiter, until stdout or stop is closed. Buffered sinks are flushed every
+// flushInterval rather than after every result, as every gzip flush adds a
+// block, and results are done once they're flushed.
+func printLn(sinks []sink, stdout chan result, stop chan struct{}, done chan struct{}, delimiter string, format func(result) string) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	written := false
+	var pending []func()
+	flush := func() {
+		flushSinks(sinks)
+		for _, f := range pending {
+			f()
+		}
+		written, pending = false, nil
+	}
+	for {
+		select {
+		case r, ok := <-stdout:
+			if !ok {
+				flush()
+				close(done)
+				return
+			}
+			printResult(sinks, r, delimiter, format)
+			written = true
+			if r.done != nil {
+				pending = append(pending, r.done)
+			}
+		case <-ticker.C:
+			if written {
+				flush()
+			}
+		case <-stop:
+			flush()
+			close(done)
+			return
+		}
+	}
+}
+
+const flushInterval = 100 * time.Millisecond
+
+func printResult(sinks []sink, r result, delimiter string, format func(result) string) {
+	atomic.AddInt64(&stats.emitted, 1)
+	s := format(r)
+	for _, k := range sinks {
+		if k.side != "all" && r.side != "" && k.side != r.side {
+			continue
+		}
+		line := s + delimiter
+		if k.color {
+			line = colorize(s, r.side) + delimiter
+		}
+		if _, err := io.WriteString(k.w, line); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func flushSinks(sinks []sink) {
+	for _, k := range sinks {
+		if f, ok := k.w.(flushWriter); ok {
+			if err := f.Flush(); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+}
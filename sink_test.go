@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSink(t *testing.T) {
+	tests := []struct {
+		s     string
+		side  string
+		path  string
+		fails bool
+	}{
+		{s: "all=-", side: "all", path: "-"},
+		{s: "left=/tmp/a=b.txt", side: "left", path: "/tmp/a=b.txt"},
+		{s: "left", fails: true},
+		{s: "left=", fails: true},
+		{s: "missing=out.txt", fails: true},
+	}
+
+	for _, ts := range tests {
+		side, path, err := parseSink(ts.s)
+		if ts.fails != (err != nil) || side != ts.side || path != ts.path {
+			t.Errorf("parsing %q returned %q, %q, %v", ts.s, side, path, err)
+		}
+	}
+}
+
+func TestPrintLnBySide(t *testing.T) {
+	var all, left, right bytes.Buffer
+	stdout := make(chan result)
+	done := make(chan struct{})
+
+	go printLn([]sink{{side: "all", w: &all}, {side: "left", w: &left}, {side: "right", w: &right}}, stdout, nil, done, "\n", formatter{}.format)
+	stdout <- result{line: "header"}
+	stdout <- result{line: "1", side: "left"}
+	stdout <- result{line: "2", side: "right"}
+	stdout <- result{line: "3", side: "common"}
+	close(stdout)
+	<-done
+
+	if all.String() != "header\n1\n2\n3\n" {
+		t.Errorf("all got %q", all.String())
+	}
+	if left.String() != "header\n1\n" {
+		t.Errorf("left got %q", left.String())
+	}
+	if right.String() != "header\n2\n" {
+		t.Errorf("right got %q", right.String())
+	}
+}
+
+func TestPrintLnStopped(t *testing.T) {
+	var b bytes.Buffer
+	stdout := make(chan result)
+	stop, done := make(chan struct{}), make(chan struct{})
+
+	go printLn([]sink{{side: "all", w: &b}}, stdout, stop, done, "\n", formatter{}.format)
+	isDone := false
+	stdout <- result{line: "1", side: "left", done: func() { isDone = true }}
+	close(stop)
+	<-done
+
+	if b.String() != "1\n" || !isDone {
+		t.Errorf("the written line wasn't done when stopped, the output was %q", b.String())
+	}
+}
+
+func TestMustOpenSinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "out.txt")
+	sinks, closeSinks := mustOpenSinks([]string{"left=" + path, "right=" + path}, false, 0, 0)
+	stdout := make(chan result)
+	done := make(chan struct{})
+
+	go printLn(sinks, stdout, nil, done, "\n", formatter{}.format)
+	stdout <- result{line: "1", side: "left"}
+	stdout <- result{line: "2", side: "common"}
+	stdout <- result{line: "3", side: "right"}
+	close(stdout)
+	<-done
+	closeSinks()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil || string(b) != "1\n3\n" {
+		t.Errorf("file wasn't '1\\n3\\n', it was %q (%v)", b, err)
+	}
+}
+
+func TestPrintLnColored(t *testing.T) {
+	var b bytes.Buffer
+	stdout := make(chan result)
+	done := make(chan struct{})
+
+	go printLn([]sink{{side: "all", w: &b, color: true}}, stdout, nil, done, "\n", formatter{numbers: true}.format)
+	stdout <- result{line: "header"}
+	stdout <- result{line: "a", side: "left", n: 1}
+	stdout <- result{line: "b", side: "right", n: 2}
+	stdout <- result{line: "c", side: "common", n: 3}
+	close(stdout)
+	<-done
+
+	expected := "header\n\x1b[31m1:a\x1b[0m\n\x1b[32m2:b\x1b[0m\n\x1b[2m3:c\x1b[0m\n"
+	if b.String() != expected {
+		t.Errorf("output wasn't %q, it was %q", expected, b.String())
+	}
+}
+
+func TestColorSinks(t *testing.T) {
+	var b bytes.Buffer
+	sinks := []sink{{side: "all", w: &b}}
+
+	for when, expected := range map[string]bool{"always": true, "never": false, "auto": false} {
+		colorSinks(sinks, when)
+		if sinks[0].color != expected {
+			t.Errorf("a buffer should be colored with --color %v: %v", when, expected)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// partitions spreads lines across n temporary files by the hash of their key,
+// so that lines with equal keys always end up in the same partition. Each key
+// and line is written prefixed by its length, so they may contain any byte,
+// followed by the line's number in its stream.
+type partitions struct {
+	files   []*os.File
+	writers []*bufio.Writer
+}
+
+func newPartitions(dir, name string, n int) (*partitions, error) {
+	p := &partitions{}
+	for i := 0; i < n; i++ {
+		f, err := os.Create(filepath.Join(dir, fmt.Sprintf("%v.%v", name, i)))
+		if err != nil {
+			p.close()
+			return nil, err
+		}
+		p.files = append(p.files, f)
+		p.writers = append(p.writers, bufio.NewWriter(f))
+	}
+
+	return p, nil
+}
+
+func (p *partitions) add(key, line string, n int) {
+	h := fnv.New32a()
+	io.WriteString(h, key)
+	if err := writeRecord(p.writers[h.Sum32()%uint32(len(p.writers))], key, line, n); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// writeRecord writes key and line prefixed by their lengths, followed by n.
+func writeRecord(w *bufio.Writer, key, line string, n int) error {
+	l := make([]byte, binary.MaxVarintLen64)
+	for _, s := range []string{key, line} {
+		w.Write(l[:binary.PutUvarint(l, uint64(len(s)))])
+		w.WriteString(s)
+	}
+	_, err := w.Write(l[:binary.PutUvarint(l, uint64(n))])
+	return err
+}
+
+// flush must be called once all lines have been added, before reading.
+func (p *partitions) flush() {
+	for _, w := range p.writers {
+		if err := w.Flush(); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func (p *partitions) read(i int, f func(key, line string, n int)) {
+	if _, err := p.files[i].Seek(0, 0); err != nil {
+		log.Fatal(err)
+	}
+
+	r := bufio.NewReader(p.files[i])
+	for {
+		key, line, n, err := readRecord(r)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		f(key, line, n)
+	}
+}
+
+// readRecord reads a record written by writeRecord, or returns io.EOF if
+// there are no more.
+func readRecord(r *bufio.Reader) (key, line string, n int, err error) {
+	if key, err = readString(r); err != nil {
+		return
+	}
+	if line, err = readString(r); err == nil {
+		var l uint64
+		l, err = binary.ReadUvarint(r)
+		n = int(l)
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return
+}
+
+func readString(r *bufio.Reader) (string, error) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (p *partitions) close() {
+	for _, f := range p.files {
+		f.Close()
+	}
+}
+
+func spillStream(ch chan string, p *partitions, key keyer, keepLines bool, c *streamCounters, t timeout, cancel chan struct{}, wg *sync.WaitGroup) {
+	t.Start()
+	canceled := false
+	n := 0
+	for {
+		select {
+		case s, ok := <-ch:
+			if !ok {
+				p.flush()
+				wg.Done()
+				return
+			}
+			atomic.AddInt64(&c.lines, 1)
+			n++
+			if keepLines {
+				p.add(key(s), s, n)
+			} else {
+				p.add(key(s), "", n)
+			}
+			t.Reset()
+		case <-*t.c:
+			if !canceled {
+				close(cancel)
+				canceled = true
+			}
+		}
+	}
+}
+
+// spillDiff produces the same output as diff, but instead of holding COMMAND
+// in memory, it partitions both streams into n temporary files each and then
+// diffs one pair of partitions at a time. Output only starts once both
+// streams have finished.
+func spillDiff(cmd string, n int, stdinTimeout timeout, cmdTimeout timeout, stdout chan result, utils iDiffUtils, intersection bool, stdinKey, cmdKey keyer) {
+	dir, err := ioutil.TempDir("", "sd")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	left, err := newPartitions(dir, "stdin", n)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer left.close()
+
+	right, err := newPartitions(dir, "cmd", n)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer right.close()
+
+	stdinCh := make(chan string)
+	cmdCh := make(chan string)
+	cancelCmd := make(chan struct{})
+	cancelStdin := make(chan struct{})
+
+	go utils.scanStdinToChannel(stdinCh, cancelStdin)
+	go utils.scanCmdToChannel(cmd, cmdCh, cancelCmd)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go spillStream(stdinCh, left, stdinKey, true, &stats.stdin, stdinTimeout, cancelStdin, &wg)
+	go spillStream(cmdCh, right, cmdKey, false, &stats.cmd, cmdTimeout, cancelCmd, &wg)
+
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		diffee := make(map[string]struct{})
+		right.read(i, func(key, _ string, _ int) {
+			diffee[key] = struct{}{}
+		})
+		left.read(i, func(key, line string, n int) {
+			if _, ok := diffee[key]; ok == intersection {
+				stdout <- result{line: line, side: side(intersection), n: n}
+			}
+		})
+	}
+
+	close(stdout)
+}
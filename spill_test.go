@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSpillDiff(t *testing.T) {
+	stdout := make(chan result)
+	reader := cmdToReader(`echo -e "1\n2\n3\n4\n5\n3"`)
+
+	go spillDiff(`echo -e "2\n4"`, 3, defaultTimeout(), defaultTimeout(), stdout, mockUtils{reader}, false, wholeLine, wholeLine)
+
+	lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{}.format)
+
+	if reflect.DeepEqual(lines, []string{"1", "3", "3", "5"}) != true {
+		t.Errorf("result wasn't ['1', '3', '3', '5'], it was %v", lines)
+	}
+}
+
+func TestSpillDiffNumbersLines(t *testing.T) {
+	stdout := make(chan result)
+	reader := cmdToReader(`echo -e "1\n2\n3"`)
+
+	go spillDiff(`echo -e "2"`, 2, defaultTimeout(), defaultTimeout(), stdout, mockUtils{reader}, false, wholeLine, wholeLine)
+
+	lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{numbers: true}.format)
+
+	if reflect.DeepEqual(lines, []string{"1:1", "3:3"}) != true {
+		t.Errorf("result wasn't ['1:1', '3:3'], it was %v", lines)
+	}
+}
+
+func TestSpillIntersection(t *testing.T) {
+	stdout := make(chan result)
+	reader := cmdToReader(`echo -e "1\n2\n3\n4"`)
+
+	go spillDiff(`echo -e "1\n3"`, 2, defaultTimeout(), defaultTimeout(), stdout, mockUtils{reader}, true, wholeLine, wholeLine)
+
+	lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{}.format)
+
+	if reflect.DeepEqual(lines, []string{"1", "3"}) != true {
+		t.Errorf("result wasn't ['1', '3'], it was %v", lines)
+	}
+}
+
+func TestSpillDiffSameAsDiff(t *testing.T) {
+	spilled := make(chan result)
+	go spillDiff(`seq 5001 10000`, 16, defaultTimeout(), defaultTimeout(), spilled, mockUtils{cmdToReader(`seq 10000`)}, false, wholeLine, wholeLine)
+	spilledLines := readResultsAndSortBlocking(spilled, 1*time.Second, formatter{}.format)
+
+	stdout := make(chan result)
+	go diff(`seq 5001 10000`, &listDiffee{}, defaultTimeout(), defaultTimeout(), stdout, mockUtils{cmdToReader(`seq 10000`)}, false, nil)
+	lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{}.format)
+
+	if len(lines) != 5000 || !reflect.DeepEqual(lines, spilledLines) {
+		t.Errorf("spilled result had %v lines and wasn't the same as the in-memory one with %v lines", len(spilledLines), len(lines))
+	}
+}
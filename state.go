@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// state is the set of lines (or their keys) seen in previous runs, each with
+// the last time it was seen. It's stored as one "unix_timestamp quoted_line" entry per line.
+type state struct {
+	sync.Mutex
+	path      string
+	retention time.Duration
+	seen      map[string]time.Time
+}
+
+func loadState(path string, retention time.Duration) (*state, error) {
+	s := &state{path: path, retention: retention, seen: make(map[string]time.Time)}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<30)
+	for i := 1; scanner.Scan(); i++ {
+		fields := strings.SplitN(scanner.Text(), " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%v:%v: malformed state entry", path, i)
+		}
+		ts, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: malformed state timestamp", path, i)
+		}
+		line, err := strconv.Unquote(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: malformed state line", path, i)
+		}
+		if t := time.Unix(ts, 0); t.After(s.seen[line]) {
+			s.seen[line] = t
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	s.expire(time.Now())
+	return s, nil
+}
+
+func mustLoadState(path string, retention time.Duration) *state {
+	s, err := loadState(path, retention)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return s
+}
+
+func (s *state) record(line string) {
+	s.Lock()
+	s.seen[line] = time.Now()
+	s.Unlock()
+}
+
+// refresh records line again if it's already in the state.
+func (s *state) refresh(line string) {
+	s.Lock()
+	if _, ok := s.seen[line]; ok {
+		s.seen[line] = time.Now()
+	}
+	s.Unlock()
+}
+
+func (s *state) lines() []string {
+	s.Lock()
+	defer s.Unlock()
+
+	lines := make([]string, 0, len(s.seen))
+	for line := range s.seen {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func (s *state) expire(now time.Time) {
+	if s.retention <= 0 {
+		return
+	}
+	for line, t := range s.seen {
+		if now.Sub(t) > s.retention {
+			delete(s.seen, line)
+		}
+	}
+}
+
+// save drops the entries older than the retention and atomically replaces
+// the state file with the remaining ones.
+func (s *state) save() error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.expire(time.Now())
+	return writeFileAtomically(s.path, func(w io.Writer) error {
+		bw := bufio.NewWriter(w)
+		for line, t := range s.seen {
+			bw.WriteString(formatStateEntry(t.Unix(), line))
+		}
+		return bw.Flush()
+	})
+}
+
+func formatStateEntry(ts int64, line string) string {
+	return fmt.Sprintf("%d %s\n", ts, strconv.Quote(line))
+}
+
+func (s *state) mustSave() {
+	if err := s.save(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// stateDiffee records the key of every line added to it in the state.
+type stateDiffee struct {
+	diffee
+	state *state
+	key   keyer
+}
+
+func (d stateDiffee) add(line string) {
+	d.state.record(d.key(line))
+	d.diffee.add(line)
+}
+
+// refreshStateDiffee refreshes the state's entries of the keys of the lines
+// it contains, so that lines that are filtered out because they're in the
+// state don't expire while they keep being seen.
+type refreshStateDiffee struct {
+	diffee
+	state *state
+	key   keyer
+}
+
+func (d refreshStateDiffee) contains(line string) bool {
+	if !d.diffee.contains(line) {
+		return false
+	}
+	d.state.refresh(d.key(line))
+	return true
+}
+
+// recordToState records the key of every line going through the returned
+// channel.
+func recordToState(stdout chan result, s *state, key keyer) chan result {
+	recorded := make(chan result)
+	go func() {
+		for r := range recorded {
+			s.record(key(r.line))
+			stdout <- r
+		}
+		close(stdout)
+	}()
+
+	return recorded
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state")
+	s, err := loadState(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.lines()) != 0 {
+		t.Errorf("a missing state file should be empty, but had %v", s.lines())
+	}
+
+	s.record("1")
+	s.record("2 with spaces")
+	s.record("3\twith\ttabs")
+	s.record("1")
+	if err := s.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = loadState(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := s.lines()
+	sort.Strings(lines)
+	if reflect.DeepEqual(lines, []string{"1", "2 with spaces", "3\twith\ttabs"}) != true {
+		t.Errorf("result wasn't ['1', '2 with spaces', '3\\twith\\ttabs'], it was %v", lines)
+	}
+}
+
+func TestStateRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state")
+	old := time.Now().Add(-2 * time.Hour).Unix()
+	recent := time.Now().Add(-30 * time.Minute).Unix()
+	ioutil.WriteFile(path, []byte(
+		formatStateEntry(old, "old")+formatStateEntry(recent, "recent")+formatStateEntry(old, "seen again")+formatStateEntry(recent, "seen again"),
+	), 0644)
+
+	s, err := loadState(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := s.lines()
+	sort.Strings(lines)
+	if reflect.DeepEqual(lines, []string{"recent", "seen again"}) != true {
+		t.Errorf("result wasn't ['recent', 'seen again'], it was %v", lines)
+	}
+}
+
+func TestLoadStateFailsOnMalformedFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("1\n2\n3\n")
+	f.Close()
+
+	if _, err := loadState(f.Name(), 0); err == nil {
+		t.Errorf("should have failed loading a malformed state file")
+	}
+}
+
+func TestDiffWithStateSkipsPreviouslyEmittedLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state")
+	runs := []struct {
+		stdin    string
+		expected []string
+	}{
+		{`echo -e "1\n2\n3"`, []string{"1", "3"}},
+		{`echo -e "1\n2\n3\n4\n5"`, []string{"4", "5"}},
+	}
+
+	for _, run := range runs {
+		s, err := loadState(path, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := &listDiffee{}
+		for _, line := range s.lines() {
+			d.add(line)
+		}
+
+		stdout := make(chan result)
+		go diff(`echo "2"`, d, defaultTimeout(), defaultTimeout(), recordToState(stdout, s, wholeLine), mockUtils{cmdToReader(run.stdin)}, false, nil)
+
+		lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{}.format)
+		if reflect.DeepEqual(lines, run.expected) != true {
+			t.Errorf("result wasn't %v, it was %v", run.expected, lines)
+		}
+		if err := s.save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStateWithKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		stdin  string
+		args   []string
+		first  []string
+		second []string
+	}{
+		{"a\tx\n", []string{"--stdin-key", "1", "--cmd-key", "2", `printf "z\tb\n"`}, []string{"a\tx"}, []string{}},
+		{"id,name\n1,a\n", []string{"--csv", "--key", "id", `printf "id,other\n2,b\n"`}, []string{"1,a", "id,name"}, []string{"id,name"}},
+	}
+	for i, ts := range tests {
+		args := append([]string{"-t", "1", "--state", filepath.Join(dir, strconv.Itoa(i))}, ts.args...)
+		for _, expected := range [][]string{ts.first, ts.second} {
+			if lines := runSd(t, ts.stdin, args...); !reflect.DeepEqual(lines, expected) {
+				t.Errorf("sd %v should have output %q, but output %q", args, expected, lines)
+			}
+		}
+	}
+}
+
+func TestStateRefreshesSeenLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state")
+	old := time.Now().Add(-50 * time.Minute).Unix()
+	ioutil.WriteFile(path, []byte(formatStateEntry(old, "1")+formatStateEntry(old, "2")), 0644)
+
+	s, err := loadState(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &listDiffee{}
+	for _, line := range s.lines() {
+		d.add(line)
+	}
+
+	stdout := make(chan result)
+	go diff(`echo 3`, refreshStateDiffee{d, s, wholeLine}, defaultTimeout(), defaultTimeout(), recordToState(stdout, s, wholeLine), mockUtils{cmdToReader(`echo -e "1\n3\n4"`)}, false, nil)
+	lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{}.format)
+	if !reflect.DeepEqual(lines, []string{"4"}) {
+		t.Errorf("result wasn't ['4'], it was %v", lines)
+	}
+
+	if s.seen["1"].Unix() == old {
+		t.Error("1 was seen again, so it should have been refreshed")
+	}
+	if s.seen["2"].Unix() != old {
+		t.Error("2 wasn't seen again, so it shouldn't have been refreshed")
+	}
+	if _, ok := s.seen["3"]; ok {
+		t.Error("3 is only in the command, so it shouldn't have been recorded")
+	}
+}
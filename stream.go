@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strconv"
+)
+
+// streamOptions configures how a stream is split into records. The zero value
+// splits lines, like bufio.ScanLines, and fails on lines longer than
+// bufio.MaxScanTokenSize.
+type streamOptions struct {
+	delimiter      string
+	delimiterRegex *regexp.Regexp
+	maxRecordSize  int
+	oversized      string
+	paragraph      bool
+	recordStart    *regexp.Regexp
+	recordLines    int
+	csv            *csvHeader
+	decompress     bool
+	encoding       string
+	invalidBytes   string
+	keyFields      []int
+	fieldSeparator string
+	numeric        bool
+}
+
+// scan sends the records in r to records.
+func (so streamOptions) scan(r io.Reader, records chan string) {
+	if so.decompress {
+		var err error
+		if r, err = decompress(r); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if so.encoding != "" {
+		var err error
+		if r, err = newDecodingReader(r, so.encoding, so.invalidBytes); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if so.csv != nil {
+		scanCSV(r, so.csv, records)
+		return
+	}
+
+	scanner := so.newScanner(r)
+	framer := so.newFramer()
+	for scanner.Scan() {
+		record, ok, err := framer.push(scanner.Text())
+		if err != nil {
+			log.Fatal(err)
+		}
+		if ok {
+			records <- record
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+	if record, ok := framer.flush(); ok {
+		records <- record
+	}
+}
+
+// key returns the keyer for the stream's records.
+func (so streamOptions) key() keyer {
+	var key keyer = wholeLine
+	switch {
+	case so.csv != nil:
+		key = so.csv.key
+	case len(so.keyFields) > 0:
+		key = fieldsKey(so.keyFields, so.fieldSeparator)
+	}
+
+	if so.numeric {
+		return numericKey(key)
+	}
+	return key
+}
+
+// keyed returns whether the stream's records are compared by something other
+// than the whole record.
+func (so streamOptions) keyed() bool {
+	return so.csv != nil || len(so.keyFields) > 0 || so.numeric
+}
+
+func (so streamOptions) newFramer() framer {
+	lines := recordLines{max: so.maxSize(), policy: so.oversized}
+	switch {
+	case so.paragraph:
+		return &paragraphFramer{lines}
+	case so.recordStart != nil:
+		return &startFramer{lines, so.recordStart}
+	case so.recordLines > 1:
+		return &countFramer{recordLines: lines, n: so.recordLines}
+	default:
+		return lineFramer{}
+	}
+}
+
+func (so streamOptions) maxSize() int {
+	if so.maxRecordSize <= 0 {
+		return bufio.MaxScanTokenSize
+	}
+	return so.maxRecordSize
+}
+
+func (so streamOptions) newScanner(r io.Reader) *bufio.Scanner {
+	max := so.maxSize()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, minInt(4096, max+1)), max+1)
+
+	var split bufio.SplitFunc
+	switch {
+	case so.delimiterRegex != nil:
+		split = splitOnRegex(so.delimiterRegex)
+	case so.delimiter != "":
+		split = splitOn([]byte(so.delimiter))
+	default:
+		split = bufio.ScanLines
+	}
+	scanner.Split(limitSplit(split, max, so.oversized))
+
+	return scanner
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// limitSplit applies the oversized policy ("fail", "truncate" or "skip") to
+// the records split that are longer than max. The scanner's buffer must be
+// max+1 bytes long, so a full buffer without a record means it's too long.
+func limitSplit(split bufio.SplitFunc, max int, policy string) bufio.SplitFunc {
+	discarding := false
+
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := split(data, atEOF)
+		if err != nil {
+			return advance, token, err
+		}
+
+		if discarding {
+			if advance > 0 {
+				discarding = false
+				return advance, nil, nil
+			}
+			if len(data) > max {
+				return len(data), nil, nil
+			}
+			return 0, nil, nil
+		}
+
+		if token == nil && !atEOF && len(data) > max {
+			advance, token = len(data), data
+			discarding = true
+		}
+		if len(token) <= max {
+			return advance, token, nil
+		}
+
+		switch policy {
+		case "truncate":
+			log.Printf("truncated a record longer than %v bytes", max)
+			return advance, token[:max], nil
+		case "skip":
+			log.Printf("skipped a record longer than %v bytes", max)
+			return advance, nil, nil
+		default:
+			return 0, nil, fmt.Errorf("found a record longer than %v bytes; see --max-record-size", max)
+		}
+	}
+}
+
+func splitOn(delimiter []byte) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if i := bytes.Index(data, delimiter); i >= 0 {
+			return i + len(delimiter), data[:i], nil
+		}
+		if atEOF {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// splitOnRegex splits on matches of re, which must not match the empty string.
+// A match that reaches the end of the data read so far could be longer once
+// more is read, so it's only used at EOF.
+func splitOnRegex(re *regexp.Regexp) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if loc := re.FindIndex(data); loc != nil && (loc[1] < len(data) || atEOF) {
+			return loc[1], data[:loc[0]], nil
+		}
+		if atEOF {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// unescape interprets the escape sequences \0, \n, \r, \t, \\ and \xHH in s,
+// so delimiters can be specified on the command line.
+func unescape(s string) (string, error) {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 == len(s) {
+			return "", fmt.Errorf("trailing \\ in %q", s)
+		}
+		i++
+		switch s[i] {
+		case '0':
+			b.WriteByte(0)
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case '\\':
+			b.WriteByte('\\')
+		case 'x':
+			if i+2 >= len(s) {
+				return "", fmt.Errorf("invalid \\x escape in %q", s)
+			}
+			c, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil {
+				return "", fmt.Errorf("invalid \\x escape in %q", s)
+			}
+			b.WriteByte(byte(c))
+			i += 2
+		default:
+			return "", fmt.Errorf("unknown escape \\%c in %q", s[i], s)
+		}
+	}
+
+	return b.String(), nil
+}
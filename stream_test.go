@@ -0,0 +1,153 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScanToChannelWithDelimiters(t *testing.T) {
+	tests := []struct {
+		input    string
+		so       streamOptions
+		expected []string
+	}{
+		{
+			input:    "1\n2\r\n3",
+			so:       streamOptions{},
+			expected: []string{"1", "2", "3"},
+		},
+		{
+			input:    "./a\x00./b with\nnewline\x00./c\x00",
+			so:       streamOptions{delimiter: "\x00"},
+			expected: []string{"./a", "./b with\nnewline", "./c"},
+		},
+		{
+			input:    "1, 2,3 ,, 4",
+			so:       streamOptions{delimiter: ", "},
+			expected: []string{"1", "2,3 ,", "4"},
+		},
+		{
+			input:    "1, 2,3 ,, 4",
+			so:       streamOptions{delimiterRegex: regexp.MustCompile(`\s*,+\s*`)},
+			expected: []string{"1", "2", "3", "4"},
+		},
+		{
+			input:    "record 1\n---\nrecord 2\n------\n",
+			so:       streamOptions{delimiterRegex: regexp.MustCompile(`\n-+\n`)},
+			expected: []string{"record 1", "record 2"},
+		},
+	}
+
+	for _, ts := range tests {
+		to := make(chan string, 10)
+		scanToChannel(strings.NewReader(ts.input), to, make(chan struct{}), ts.so)
+
+		lines := []string{}
+		for line := range to {
+			lines = append(lines, line)
+		}
+
+		if !reflect.DeepEqual(lines, ts.expected) {
+			t.Errorf("splitting %q should have resulted in %q, but it was %q", ts.input, ts.expected, lines)
+		}
+	}
+}
+
+func TestScanToChannelWithOversizedRecords(t *testing.T) {
+	long := strings.Repeat("x", 10000)
+	input := "1\n" + long + "\n2\n" + long + "y"
+
+	tests := []struct {
+		so       streamOptions
+		expected []string
+	}{
+		{
+			so:       streamOptions{maxRecordSize: 100, oversized: "truncate"},
+			expected: []string{"1", long[:100], "2", long[:100]},
+		},
+		{
+			so:       streamOptions{maxRecordSize: 100, oversized: "skip"},
+			expected: []string{"1", "2"},
+		},
+		{
+			so:       streamOptions{maxRecordSize: 100, oversized: "truncate", delimiter: "\n2\n"},
+			expected: []string{"1\n" + long[:98], long[:100]},
+		},
+		{
+			so:       streamOptions{maxRecordSize: 20000, oversized: "fail"},
+			expected: []string{"1", long, "2", long + "y"},
+		},
+	}
+
+	for _, ts := range tests {
+		to := make(chan string, 10)
+		scanToChannel(strings.NewReader(input), to, make(chan struct{}), ts.so)
+
+		lines := []string{}
+		for line := range to {
+			lines = append(lines, line)
+		}
+
+		if !reflect.DeepEqual(lines, ts.expected) {
+			t.Errorf("with %+v, result had %v records, but should have had %v", ts.so, len(lines), len(ts.expected))
+		}
+	}
+}
+
+func TestScannerFailsOnOversizedRecords(t *testing.T) {
+	input := "1\n" + strings.Repeat("x", 70000) + "\n2\n"
+
+	scanner := streamOptions{oversized: "fail"}.newScanner(strings.NewReader(input))
+	for scanner.Scan() {
+	}
+
+	if scanner.Err() == nil {
+		t.Errorf("scanning a record longer than 64KiB should have failed")
+	}
+}
+
+func TestReadCmdWithNulDelimiter(t *testing.T) {
+	o := make(chan string, 2)
+
+	readCmd(`printf "1\n2\0003"`, o, make(chan struct{}), streamOptions{delimiter: "\x00"})
+
+	lines := readAndSortBlocking(o, 1*time.Second)
+
+	if reflect.DeepEqual(lines, []string{"1\n2", "3"}) != true {
+		t.Errorf("result wasn't ['1\\n2', '3'], it was %q", lines)
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected string
+		fails    bool
+	}{
+		{s: `,`, expected: ","},
+		{s: `\0`, expected: "\x00"},
+		{s: `\t|\n`, expected: "\t|\n"},
+		{s: `\r\n`, expected: "\r\n"},
+		{s: `\\`, expected: `\`},
+		{s: `\x1e`, expected: "\x1e"},
+		{s: `\x1`, fails: true},
+		{s: `\xzz`, fails: true},
+		{s: `\q`, fails: true},
+		{s: `\`, fails: true},
+	}
+
+	for _, ts := range tests {
+		result, err := unescape(ts.s)
+
+		if ts.fails && err == nil {
+			t.Errorf("unescaping %q should have failed", ts.s)
+		}
+
+		if !ts.fails && result != ts.expected {
+			t.Errorf("unescaping %q should have resulted in %q, but it was %q", ts.s, ts.expected, result)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
-import "time"
+import (
+	"sync/atomic"
+	"time"
+)
 
 type timeout struct {
 	hard              bool
@@ -11,12 +14,18 @@ type timeout struct {
 
 	c     *<-chan time.Time
 	timer *time.Timer
+
+	// deadline and resets are shared by the copies of the timeout, so they can
+	// be reported while it runs. They may be nil.
+	deadline *deadline
+	resets   *int64
 }
 
 func (t *timeout) Start() {
 	if !t.infinite && !t.firstTimeInfinite {
 		t.timer = time.NewTimer(t.firstTime)
 		t.c = &t.timer.C
+		t.deadline.set(time.Now().Add(t.firstTime))
 	} else {
 		ch := make(<-chan time.Time)
 		t.c = &ch
@@ -31,7 +40,39 @@ func (t *timeout) Reset() {
 		} else {
 			t.timer.Reset(t.time)
 		}
+		t.deadline.set(time.Now().Add(t.time))
+		if t.resets != nil {
+			atomic.AddInt64(t.resets, 1)
+		}
 	} else {
 		time.Sleep(1 * time.Millisecond)
 	}
 }
+
+// deadline is when a timeout fires, or the zero time if it never does.
+type deadline struct {
+	nanos int64
+}
+
+func (d *deadline) set(t time.Time) {
+	if d != nil {
+		atomic.StoreInt64(&d.nanos, t.UnixNano())
+	}
+}
+
+// clear is called once the timeout's stream finishes.
+func (d *deadline) clear() {
+	if d != nil {
+		atomic.StoreInt64(&d.nanos, 0)
+	}
+}
+
+func (d *deadline) get() (time.Time, bool) {
+	if d == nil {
+		return time.Time{}, false
+	}
+	if nanos := atomic.LoadInt64(&d.nanos); nanos != 0 {
+		return time.Unix(0, nanos), true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,115 @@
+package main
+
+import "sync"
+
+// trieDiffee contains the lines that start with (or, if substring is set,
+// contain) any of its lines. Lines are kept in a trie, which is turned into
+// an Aho-Corasick automaton on the first substring query, so lines can't be
+// added after that.
+type trieDiffee struct {
+	substring bool
+	nodes     []trieNode
+	built     sync.Once
+}
+
+type trieNode struct {
+	next map[byte]int
+	// fail is the node of the longest proper suffix of this node's path that's
+	// also a path in the trie.
+	fail int
+	// terminal is set if a line ends at this node or, once built, at any node
+	// in its chain of fail links.
+	terminal bool
+}
+
+func newTrieDiffee(substring bool) *trieDiffee {
+	return &trieDiffee{substring: substring, nodes: []trieNode{{}}}
+}
+
+func (d *trieDiffee) add(s string) {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		next, ok := d.nodes[n].next[s[i]]
+		if !ok {
+			if d.nodes[n].next == nil {
+				d.nodes[n].next = make(map[byte]int)
+			}
+			next = len(d.nodes)
+			d.nodes[n].next[s[i]] = next
+			d.nodes = append(d.nodes, trieNode{})
+		}
+		n = next
+	}
+	d.nodes[n].terminal = true
+}
+
+func (d *trieDiffee) contains(s string) bool {
+	if d.substring {
+		d.built.Do(d.build)
+		return d.containsSubstring(s)
+	}
+
+	n := 0
+	for i := 0; i < len(s) && !d.nodes[n].terminal; i++ {
+		next, ok := d.nodes[n].next[s[i]]
+		if !ok {
+			return false
+		}
+		n = next
+	}
+	return d.nodes[n].terminal
+}
+
+func (d *trieDiffee) containsSubstring(s string) bool {
+	n := 0
+	if d.nodes[n].terminal {
+		return true
+	}
+	for i := 0; i < len(s); i++ {
+		for {
+			if next, ok := d.nodes[n].next[s[i]]; ok {
+				n = next
+				break
+			}
+			if n == 0 {
+				break
+			}
+			n = d.nodes[n].fail
+		}
+		if d.nodes[n].terminal {
+			return true
+		}
+	}
+	return false
+}
+
+// build sets the fail links breadth first, so the fail links of shorter paths
+// are set before they're followed.
+func (d *trieDiffee) build() {
+	queue := []int{}
+	for _, child := range d.nodes[0].next {
+		queue = append(queue, child)
+	}
+
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+
+		for c, child := range d.nodes[n].next {
+			f := d.nodes[n].fail
+			for {
+				if next, ok := d.nodes[f].next[c]; ok {
+					f = next
+					break
+				}
+				if f == 0 {
+					break
+				}
+				f = d.nodes[f].fail
+			}
+			d.nodes[child].fail = f
+			d.nodes[child].terminal = d.nodes[child].terminal || d.nodes[f].terminal
+			queue = append(queue, child)
+		}
+	}
+}
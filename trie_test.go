@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrieDiffeePrefixes(t *testing.T) {
+	d := newTrieDiffee(false)
+	for _, s := range []string{"/admin/", "/api/v1/", "/api/"} {
+		d.add(s)
+	}
+
+	tests := map[string]bool{
+		"/admin/users":    true,
+		"/admin":          false,
+		"/api/v2/users":   true,
+		"/api":            false,
+		"/public/api/v1/": false,
+		"":                false,
+	}
+	for s, expected := range tests {
+		if d.contains(s) != expected {
+			t.Errorf("contains(%q) wasn't %v", s, expected)
+		}
+	}
+}
+
+func TestTrieDiffeeSubstrings(t *testing.T) {
+	d := newTrieDiffee(true)
+	for _, s := range []string{"he", "she", "his", "hers", "abcd", "bc"} {
+		d.add(s)
+	}
+
+	tests := map[string]bool{
+		"ushers":  true,
+		"ahishe":  true,
+		"xhxixs":  false,
+		"abce":    true,
+		"abxcd":   false,
+		"h":       false,
+		"":        false,
+		"sh":      false,
+		"zzzhers": true,
+	}
+	for s, expected := range tests {
+		if d.contains(s) != expected {
+			t.Errorf("contains(%q) wasn't %v", s, expected)
+		}
+	}
+}
+
+func TestEmptyLineMatchesEverything(t *testing.T) {
+	for _, substring := range []bool{false, true} {
+		d := newTrieDiffee(substring)
+		d.add("")
+		if !d.contains("anything") || !d.contains("") {
+			t.Errorf("an empty line didn't match everything with substring = %v", substring)
+		}
+	}
+}
+
+func TestDiffAgainstPrefixes(t *testing.T) {
+	stdout := make(chan result)
+	reader := strings.NewReader("/admin/users\n/home\n/api/v1/orders\n")
+
+	go diff(`echo -e "/admin/\n/api/"`, newTrieDiffee(false), defaultTimeout(), defaultTimeout(), stdout, mockUtils{reader}, false, nil)
+
+	lines := readResultsAndSortBlocking(stdout, 1*time.Second, formatter{}.format)
+
+	if reflect.DeepEqual(lines, []string{"/home"}) != true {
+		t.Errorf("result wasn't ['/home'], it was %v", lines)
+	}
+}